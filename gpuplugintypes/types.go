@@ -4,6 +4,18 @@ import "github.com/Microsoft/KubeDevice-API/pkg/types"
 
 const (
 	ResourceGPU types.ResourceName = "nvidia.com/gpu"
+	// node attributes, advertised in the capacity of the node only, so they are never allocated
+	// highest CUDA version supported by the driver, encoded by EncodeCUDAVersion
+	AttributeCUDAVersion types.ResourceName = "nvidia.com/cuda-version"
+	// driver version, encoded by EncodeDriverVersion
+	AttributeDriverVersion types.ResourceName = "nvidia.com/driver-version"
+	// pod level requests for the lowest CUDA and driver versions the pod can run with, they are not read from
+	// annotations of their own but from the pod requests of the device annotation of the pod, like the topology
+	// generation, and must be encoded as integers as above, e.g. 11040 for CUDA 11.4 or 470082001 for driver 470.82.01
+	ResourceMinCUDAVersion   types.ResourceName = "nvidia.com/min-cuda-version"
+	ResourceMinDriverVersion types.ResourceName = "nvidia.com/min-driver-version"
+	// container requests for RDMA NICs, placed in the gpugrp0 group of the GPUs of the container
+	ResourceRDMANIC types.ResourceName = "kubegpu.microsoft.com/rdma-nic"
 )
 
 type SortedTreeNode struct {
@@ -32,3 +32,43 @@ func TestSortedTreeNode(t *testing.T) {
 		t.Errorf("Trees not equal\n")
 	}
 }
+
+func TestVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"418.87.01", "418.87.1", 0},
+		{"10.1", "10.1.0", 0},
+		{"9.2", "10.0", -1},
+		{"525.60.13", "470.42.01", 1},
+		{"418.87", "418.87.01", -1},
+	}
+	for _, c := range cases {
+		cmp, err := CompareVersions(c.a, c.b)
+		if err != nil || cmp != c.expected {
+			t.Errorf("Compare %v with %v, expected %v, have %v %v", c.a, c.b, c.expected, cmp, err)
+		}
+	}
+	if _, err := CompareVersions("10.x", "10.1"); err == nil {
+		t.Errorf("Expected error for invalid version")
+	}
+	if cuda, err := EncodeCUDAVersion("10.1"); err != nil || cuda != 10010 {
+		t.Errorf("Unexpected CUDA encoding %v %v", cuda, err)
+	}
+	if cuda, err := EncodeCUDAVersion("12"); err != nil || cuda != 12000 {
+		t.Errorf("Unexpected CUDA encoding %v %v", cuda, err)
+	}
+	if _, err := EncodeCUDAVersion(""); err == nil {
+		t.Errorf("Expected error for unknown CUDA version")
+	}
+	if driver, err := EncodeDriverVersion("418.87.01"); err != nil || driver != 418087001 || DecodeDriverVersion(driver) != "418.87.01" {
+		t.Errorf("Unexpected driver encoding %v %v", driver, err)
+	}
+	if driver, err := EncodeDriverVersion("375.20"); err != nil || DecodeDriverVersion(driver) != "375.20" {
+		t.Errorf("Unexpected driver encoding %v %v", driver, err)
+	}
+	if DecodeCUDAVersion(12020) != "12.2" {
+		t.Errorf("Unexpected CUDA decoding %v", DecodeCUDAVersion(12020))
+	}
+}
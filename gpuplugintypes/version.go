@@ -0,0 +1,98 @@
+package gpuplugintypes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseVersion parses a dotted version such as "418.87.01" or "10.1" into its numeric components
+func ParseVersion(version string) ([]int64, error) {
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return nil, fmt.Errorf("empty version")
+	}
+	parts := strings.Split(version, ".")
+	nums := make([]int64, len(parts))
+	for i, part := range parts {
+		num, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || num < 0 {
+			return nil, fmt.Errorf("invalid version %v", version)
+		}
+		nums[i] = num
+	}
+	return nums, nil
+}
+
+// CompareVersions returns -1, 0 or 1 if version a is lower than, equal to or higher than version b
+// missing components count as zero, so "10.1" equals "10.1.0"
+func CompareVersions(a string, b string) (int, error) {
+	numsA, err := ParseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	numsB, err := ParseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(numsA) || i < len(numsB); i++ {
+		var numA, numB int64
+		if i < len(numsA) {
+			numA = numsA[i]
+		}
+		if i < len(numsB) {
+			numB = numsB[i]
+		}
+		if numA < numB {
+			return -1, nil
+		}
+		if numA > numB {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// EncodeCUDAVersion encodes a CUDA version as major*1000 + minor*10, the encoding of CUDA_VERSION, e.g. 10010 for 10.1
+func EncodeCUDAVersion(version string) (int64, error) {
+	nums, err := ParseVersion(version)
+	if err != nil {
+		return 0, err
+	}
+	if len(nums) < 2 {
+		nums = append(nums, 0)
+	}
+	if nums[1] > 99 {
+		return 0, fmt.Errorf("invalid CUDA version %v", version)
+	}
+	return nums[0]*1000 + nums[1]*10, nil
+}
+
+// DecodeCUDAVersion returns the CUDA version encoded by EncodeCUDAVersion
+func DecodeCUDAVersion(encoded int64) string {
+	return fmt.Sprintf("%d.%d", encoded/1000, (encoded%1000)/10)
+}
+
+// EncodeDriverVersion encodes a driver version as major*1000000 + minor*1000 + patch, e.g. 418087001 for 418.87.01
+func EncodeDriverVersion(version string) (int64, error) {
+	nums, err := ParseVersion(version)
+	if err != nil {
+		return 0, err
+	}
+	for len(nums) < 3 {
+		nums = append(nums, 0)
+	}
+	if len(nums) > 3 || nums[1] > 999 || nums[2] > 999 {
+		return 0, fmt.Errorf("invalid driver version %v", version)
+	}
+	return nums[0]*1000000 + nums[1]*1000 + nums[2], nil
+}
+
+// DecodeDriverVersion returns the driver version encoded by EncodeDriverVersion
+func DecodeDriverVersion(encoded int64) string {
+	major, minor, patch := encoded/1000000, (encoded%1000000)/1000, encoded%1000
+	if patch == 0 {
+		return fmt.Sprintf("%d.%d", major, minor)
+	}
+	return fmt.Sprintf("%d.%d.%02d", major, minor, patch)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/types"
+	"github.com/Microsoft/KubeGPU/gpuschedulerplugin"
+)
+
+// exit codes
+const (
+	exitOK      = 0
+	exitError   = 1 // the node or pod cannot be read
+	exitUsage   = 2
+	exitNotFits = 3 // the pod does not fit the node
+)
+
+func readJSON(path string, v interface{}) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("parsing %v fails: %v", path, err)
+	}
+	return nil
+}
+
+func readNodeInfo(path string) (*types.NodeInfo, error) {
+	nodeInfo := types.NewNodeInfo()
+	if err := readJSON(path, nodeInfo); err != nil {
+		return nil, err
+	}
+	return nodeInfo, nil
+}
+
+func main() {
+	var nodePath = flag.String("node", "", "NodeInfo JSON file of the node to explain the pod on.")
+	var podPath = flag.String("pod", "", "PodInfo JSON file of the pod.")
+	var asJSON = flag.Bool("json", false, "Print the explanation as JSON.")
+	flag.Parse()
+	if *nodePath == "" || *podPath == "" || flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	nodeInfo, err := readNodeInfo(*nodePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading node: %v\n", err)
+		os.Exit(exitError)
+	}
+	var podInfo types.PodInfo
+	if err := readJSON(*podPath, &podInfo); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading pod: %v\n", err)
+		os.Exit(exitError)
+	}
+	exp := gpuschedulerplugin.Explain(nodeInfo, &podInfo)
+	if *asJSON {
+		body, _ := json.MarshalIndent(exp, "", "  ")
+		fmt.Printf("%s\n", body)
+	} else {
+		fmt.Printf("%v", exp)
+	}
+	if !exp.Fits {
+		os.Exit(exitNotFits)
+	}
+	os.Exit(exitOK)
+}
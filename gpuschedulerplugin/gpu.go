@@ -185,6 +185,7 @@ func computeTreeScoreAtLevel(node *sctypes.SortedTreeNode, level int, numChild i
 	return score
 }
 
+// computeTreeScore scores a placement tree, trees of fully connected nodes are flat and score the same for any subset
 func computeTreeScore(node *sctypes.SortedTreeNode) float64 {
 	return computeTreeScoreAtLevel(node, 0, len(node.Child))
 }
@@ -0,0 +1,271 @@
+package gpuschedulerplugin
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	types "github.com/Microsoft/KubeDevice-API/pkg/types"
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+	gputypes "github.com/Microsoft/KubeGPU/gpuplugintypes"
+)
+
+// topology modes selected by the GPUTopologyGeneration pod request
+const (
+	TopologyModeAuto    = "auto"
+	TopologyModeNone    = "none"
+	TopologyModeInvalid = "invalid"
+)
+
+// ContainerExplanation describes the GPU requests of a container and the node GPUs they are matched to
+type ContainerExplanation struct {
+	Name         string
+	Init         bool
+	KubeGPUs     int64 // GPUs in the Kubernetes requests
+	DeviceGPUs   int64 // GPUs in the device requests
+	GPUs         int64 // GPUs after SetGPUReqs
+	DevRequests  types.ResourceList
+	AllocateFrom types.ResourceLocation // requested GPU -> node GPU, nil if not matched
+	Failure      string
+}
+
+// Explanation describes step by step how a pod is translated and matched against a node
+type Explanation struct {
+	Node           string
+	Pod            string
+	VersionReasons []string
+	NumGPUs        int64 // GPUs of the running containers summed up, or of the largest init container
+	TopologyMode   string
+	Tree           *gputypes.SortedTreeNode // tree of the free GPUs of the node the pod is placed on, nil without topology
+	Placements     []Candidate              // placements enumerated on the tree, best first
+	Containers     []ContainerExplanation
+	FreeGPUs       map[string]int // free GPUs by gpugrp1/<id>/gpugrp0/<id> group of the node
+	Fits           bool
+	Failure        string // the first step at which the pod does not fit
+}
+
+func (exp *Explanation) fail(failure string) {
+	if exp.Fits {
+		exp.Fits = false
+		exp.Failure = failure
+	}
+}
+
+func copyResourceList(list types.ResourceList) types.ResourceList {
+	listCopy := make(types.ResourceList)
+	for key, val := range list {
+		listCopy[key] = val
+	}
+	return listCopy
+}
+
+func copyContainers(conts map[string]types.ContainerInfo) map[string]types.ContainerInfo {
+	contsCopy := make(map[string]types.ContainerInfo)
+	for name, cont := range conts {
+		contCopy := types.ContainerInfo{
+			KubeRequests: copyResourceList(cont.KubeRequests),
+			Requests:     copyResourceList(cont.Requests),
+			DevRequests:  copyResourceList(cont.DevRequests),
+			Scorer:       make(types.ResourceScorer),
+			AllocateFrom: make(types.ResourceLocation),
+		}
+		for key, val := range cont.Scorer {
+			contCopy.Scorer[key] = val
+		}
+		for key, val := range cont.AllocateFrom {
+			contCopy.AllocateFrom[key] = val
+		}
+		contsCopy[name] = contCopy
+	}
+	return contsCopy
+}
+
+// copyPodInfo returns a deep copy of the pod info, translating the copy leaves the original unchanged
+func copyPodInfo(podInfo *types.PodInfo) *types.PodInfo {
+	return &types.PodInfo{
+		Name:              podInfo.Name,
+		NodeName:          podInfo.NodeName,
+		Requests:          copyResourceList(podInfo.Requests),
+		InitContainers:    copyContainers(podInfo.InitContainers),
+		RunningContainers: copyContainers(podInfo.RunningContainers),
+	}
+}
+
+// Explain explains the pod on the node with the default placement limit and scorer, see NvidiaGPUScheduler.Explain
+func Explain(nodeInfo *types.NodeInfo, podInfo *types.PodInfo) *Explanation {
+	return (&NvidiaGPUScheduler{}).Explain(nodeInfo, podInfo)
+}
+
+// Explain commits the placement Evaluate returns to a copy of the pod like PodAllocate does and matches the translated GPU requests against the
+// free GPUs of the node with MatchPod, recording every step and the first one at which the pod does not fit, the node,
+// the pod and the node tree cache are not modified
+// only GPU cards are matched, other group resources such as GPU memory are left to the group scheduler
+func (ns *NvidiaGPUScheduler) Explain(nodeInfo *types.NodeInfo, podInfo *types.PodInfo) *Explanation {
+	exp := &Explanation{Node: nodeInfo.Name, Pod: podInfo.Name, Fits: true}
+	placement := ns.Evaluate(nodeInfo, podInfo)
+	topologyReasons := []string{}
+	for _, reason := range placement.Reasons {
+		if _, ok := reason.(*InsufficientVersion); ok {
+			exp.VersionReasons = append(exp.VersionReasons, reason.GetReason())
+		} else {
+			topologyReasons = append(topologyReasons, reason.GetReason())
+		}
+	}
+	if len(exp.VersionReasons) > 0 {
+		exp.fail("versions: " + strings.Join(exp.VersionReasons, "; "))
+	}
+
+	pod := copyPodInfo(podInfo)
+	for _, init := range []bool{false, true} {
+		conts := pod.RunningContainers
+		if init {
+			conts = pod.InitContainers
+		}
+		for _, name := range utils.SortedStringKeys(conts) {
+			cont := conts[name]
+			contExp := ContainerExplanation{Name: name, Init: init, KubeGPUs: cont.KubeRequests[gputypes.ResourceGPU], DeviceGPUs: cont.Requests[gputypes.ResourceGPU]}
+			SetGPUReqs(&cont)
+			contExp.GPUs = cont.Requests[gputypes.ResourceGPU]
+			exp.Containers = append(exp.Containers, contExp)
+		}
+	}
+	numGPUs, _ := podGPUs(pod)
+	exp.NumGPUs = int64(numGPUs)
+
+	exp.TopologyMode = placement.TopologyMode
+	exp.Tree = placement.Tree
+	exp.Placements = placement.Candidates
+	if len(topologyReasons) > 0 {
+		exp.fail("topology: " + strings.Join(topologyReasons, "; "))
+		return exp
+	}
+	if !exp.Fits {
+		return exp
+	}
+
+	// the placement is committed like PodAllocate does
+	if err := placement.Commit(pod); err != nil {
+		exp.fail("allocate: " + err.Error())
+		return exp
+	}
+	for i := range exp.Containers {
+		contExp := &exp.Containers[i]
+		if contExp.Init {
+			contExp.DevRequests = pod.InitContainers[contExp.Name].DevRequests
+		} else {
+			contExp.DevRequests = pod.RunningContainers[contExp.Name].DevRequests
+		}
+	}
+
+	exp.FreeGPUs = make(map[string]int)
+	for grp1, grp0s := range groupGPUs(freeGPUs(nodeInfo)) {
+		for grp0, gpus := range grp0s {
+			exp.FreeGPUs["gpugrp1/"+grp1+"/gpugrp0/"+grp0] = len(gpus)
+		}
+	}
+	matches, failure := MatchPod(nodeInfo, pod)
+	for i := range exp.Containers {
+		contExp := &exp.Containers[i]
+		contExp.AllocateFrom = matches[contExp.Name]
+		if failure != nil && failure.Container == contExp.Name && failure.Init == contExp.Init {
+			contExp.Failure = failure.Reason
+		}
+	}
+	if failure != nil {
+		exp.fail("matching: " + failure.Error())
+	}
+	return exp
+}
+
+// treeString formats a tree on one line, e.g. 8[4[2 2] 4[2 2]]
+func treeString(node *gputypes.SortedTreeNode) string {
+	if node == nil {
+		return "-"
+	}
+	if len(node.Child) == 0 {
+		return strconv.Itoa(node.Val)
+	}
+	children := []string{}
+	for _, child := range node.Child {
+		children = append(children, treeString(child))
+	}
+	return strconv.Itoa(node.Val) + "[" + strings.Join(children, " ") + "]"
+}
+
+func formatResources(buffer *bytes.Buffer, indent string, list types.ResourceList) {
+	for _, key := range utils.SortedStringKeys(list) {
+		buffer.WriteString(fmt.Sprintf("%v%v: %v\n", indent, key, list[types.ResourceName(key)]))
+	}
+}
+
+// String formats the explanation as numbered steps
+func (exp *Explanation) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Pod %v on node %v\n", exp.Pod, exp.Node))
+
+	buffer.WriteString("1. Versions: ")
+	if len(exp.VersionReasons) == 0 {
+		buffer.WriteString("ok\n")
+	} else {
+		buffer.WriteString("does not fit\n")
+		for _, reason := range exp.VersionReasons {
+			buffer.WriteString("   " + reason + "\n")
+		}
+	}
+
+	buffer.WriteString("2. GPU requests after SetGPUReqs:\n")
+	for _, contExp := range exp.Containers {
+		kind := "container"
+		if contExp.Init {
+			kind = "init container"
+		}
+		buffer.WriteString(fmt.Sprintf("   %v %v: %v GPUs (kube %v, device %v)\n", kind, contExp.Name, contExp.GPUs, contExp.KubeGPUs, contExp.DeviceGPUs))
+	}
+	buffer.WriteString(fmt.Sprintf("   pod needs %v GPUs\n", exp.NumGPUs))
+
+	buffer.WriteString("3. Topology: " + exp.TopologyMode + "\n")
+	if exp.TopologyMode == TopologyModeAuto {
+		buffer.WriteString(fmt.Sprintf("   tree %v\n", treeString(exp.Tree)))
+		for i, candidate := range exp.Placements {
+			mark := "          "
+			if i == 0 {
+				mark = "[chosen]  "
+			}
+			buffer.WriteString(fmt.Sprintf("   %vplacement %v score %.2f\n", mark, candidate.Groups, candidate.Score))
+		}
+	}
+
+	buffer.WriteString("4. Translated requests:\n")
+	for _, contExp := range exp.Containers {
+		buffer.WriteString(fmt.Sprintf("   %v:\n", contExp.Name))
+		formatResources(&buffer, "     ", contExp.DevRequests)
+	}
+
+	buffer.WriteString("5. Free GPUs of the node by group:\n")
+	if len(exp.FreeGPUs) == 0 {
+		buffer.WriteString("   none\n")
+	}
+	for _, group := range utils.SortedStringKeys(exp.FreeGPUs) {
+		buffer.WriteString(fmt.Sprintf("   %v: %v\n", group, exp.FreeGPUs[group]))
+	}
+
+	buffer.WriteString("6. Matching:\n")
+	for _, contExp := range exp.Containers {
+		if contExp.AllocateFrom != nil {
+			buffer.WriteString(fmt.Sprintf("   %v:\n", contExp.Name))
+			for _, key := range utils.SortedStringKeys(contExp.AllocateFrom) {
+				buffer.WriteString(fmt.Sprintf("     %v -> %v\n", key, contExp.AllocateFrom[types.ResourceName(key)]))
+			}
+		} else if contExp.Failure != "" {
+			buffer.WriteString(fmt.Sprintf("   %v: %v\n", contExp.Name, contExp.Failure))
+		}
+	}
+
+	if exp.Fits {
+		buffer.WriteString("Result: fits\n")
+	} else {
+		buffer.WriteString("Result: does not fit, " + exp.Failure + "\n")
+	}
+	return buffer.String()
+}
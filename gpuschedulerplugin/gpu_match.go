@@ -0,0 +1,164 @@
+package gpuschedulerplugin
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	types "github.com/Microsoft/KubeDevice-API/pkg/types"
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+)
+
+// matches GPU cards of nodes and of translated requests, with their gpugrp1 and gpugrp0 groups
+var gpuCardsRE = regexp.MustCompile(`^` + types.DeviceGroupPrefix + `/gpugrp1/([^/]+)/gpugrp0/([^/]+)/gpu/([^/]+)/cards$`)
+
+// MatchFailure is the container whose GPU requests cannot be matched to the free GPUs of a node, and why
+type MatchFailure struct {
+	Container string
+	Init      bool
+	Reason    string
+}
+
+func (f *MatchFailure) Error() string {
+	return fmt.Sprintf("container %v: %v", f.Container, f.Reason)
+}
+
+// freeGPUs returns the GPU cards of the node which are not used, translated to two levels of groups like AddNode does
+func freeGPUs(nodeInfo *types.NodeInfo) map[string]bool {
+	return availableGPUs(nodeGPUResources(nodeInfo, copyResourceList(nodeInfo.Allocatable)), nodeInfo.Used)
+}
+
+// availableGPUs returns the GPU cards of the node which are not used
+func availableGPUs(alloc types.ResourceList, used types.ResourceList) map[string]bool {
+	available := make(map[string]bool)
+	for res, val := range alloc {
+		if gpuCardsRE.MatchString(string(res)) && val-used[res] > 0 {
+			available[string(res)] = true
+		}
+	}
+	return available
+}
+
+// groupGPUs groups GPU cards by gpugrp1 and gpugrp0, in sorted order
+func groupGPUs(gpus map[string]bool) map[string]map[string][]string {
+	groups := make(map[string]map[string][]string)
+	for _, res := range utils.SortedStringKeys(gpus) {
+		matches := gpuCardsRE.FindStringSubmatch(res)
+		if groups[matches[1]] == nil {
+			groups[matches[1]] = make(map[string][]string)
+		}
+		groups[matches[1]][matches[2]] = append(groups[matches[1]][matches[2]], res)
+	}
+	return groups
+}
+
+func numInGroups(groups map[string][]string) int {
+	num := 0
+	for _, gpus := range groups {
+		num += len(gpus)
+	}
+	return num
+}
+
+// fitGrp0s maps each requested gpugrp0 group to a distinct free gpugrp0 group, the smallest fitting one first
+func fitGrp0s(free map[string][]string, requested map[string][]string) types.ResourceLocation {
+	reqGrp0s := utils.SortedStringKeys(requested)
+	sort.SliceStable(reqGrp0s, func(i, j int) bool { return len(requested[reqGrp0s[i]]) > len(requested[reqGrp0s[j]]) })
+	grp0s := utils.SortedStringKeys(free)
+	sort.SliceStable(grp0s, func(i, j int) bool { return len(free[grp0s[i]]) < len(free[grp0s[j]]) })
+	used := make(map[string]bool)
+	allocateFrom := make(types.ResourceLocation)
+	for _, reqGrp0 := range reqGrp0s {
+		found := false
+		for _, grp0 := range grp0s {
+			if !used[grp0] && len(free[grp0]) >= len(requested[reqGrp0]) {
+				used[grp0] = true
+				for i, res := range requested[reqGrp0] {
+					allocateFrom[types.ResourceName(res)] = types.ResourceName(free[grp0][i])
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return allocateFrom
+}
+
+// matchGPUs maps the requested GPU cards to available GPU cards of the node, requested groups are mapped to distinct
+// groups of the node, the gpugrp1 groups with the fewest free GPUs first
+// returns the reason if the requests cannot be matched
+func matchGPUs(available map[string]bool, requests types.ResourceList) (types.ResourceLocation, string) {
+	requestedGPUs := make(map[string]bool)
+	for res := range requests {
+		if gpuCardsRE.MatchString(string(res)) {
+			requestedGPUs[string(res)] = true
+		}
+	}
+	requested := groupGPUs(requestedGPUs)
+	free := groupGPUs(available)
+	reqGrp1s := utils.SortedStringKeys(requested)
+	sort.SliceStable(reqGrp1s, func(i, j int) bool { return numInGroups(requested[reqGrp1s[i]]) > numInGroups(requested[reqGrp1s[j]]) })
+	grp1s := utils.SortedStringKeys(free)
+	sort.SliceStable(grp1s, func(i, j int) bool { return numInGroups(free[grp1s[i]]) < numInGroups(free[grp1s[j]]) })
+	usedGrp1 := make(map[string]bool)
+	allocateFrom := make(types.ResourceLocation)
+	for _, reqGrp1 := range reqGrp1s {
+		found := false
+		for _, grp1 := range grp1s {
+			if usedGrp1[grp1] {
+				continue
+			}
+			if grp0From := fitGrp0s(free[grp1], requested[reqGrp1]); grp0From != nil {
+				usedGrp1[grp1] = true
+				for key, val := range grp0From {
+					allocateFrom[key] = val
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			sizes := []int{}
+			for _, reqGrp0 := range utils.SortedStringKeys(requested[reqGrp1]) {
+				sizes = append(sizes, len(requested[reqGrp1][reqGrp0]))
+			}
+			sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+			return nil, fmt.Sprintf("requested group gpugrp1/%v needs gpugrp0 groups of %v GPUs, no remaining gpugrp1 group of the node has them free",
+				reqGrp1, sizes)
+		}
+	}
+	return allocateFrom, ""
+}
+
+// MatchPod matches the translated GPU requests of the containers of the pod to the free GPU cards of the node, requested
+// groups are mapped to distinct groups of the node like the group scheduler does, running containers share the node,
+// init containers run before them one at a time, only GPU cards are matched
+// returns the node GPU each requested GPU is matched to by container, up to the first container which does not match
+func MatchPod(nodeInfo *types.NodeInfo, podInfo *types.PodInfo) (map[string]types.ResourceLocation, *MatchFailure) {
+	available := freeGPUs(nodeInfo)
+	matches := make(map[string]types.ResourceLocation)
+	for _, init := range []bool{false, true} {
+		conts := podInfo.RunningContainers
+		if init {
+			conts = podInfo.InitContainers
+		}
+		for _, name := range utils.SortedStringKeys(conts) {
+			pool := available
+			if init {
+				pool = freeGPUs(nodeInfo)
+			}
+			allocateFrom, reason := matchGPUs(pool, conts[name].DevRequests)
+			if allocateFrom == nil {
+				return matches, &MatchFailure{Container: name, Init: init, Reason: reason}
+			}
+			matches[name] = allocateFrom
+			for _, nodeRes := range allocateFrom {
+				delete(pool, string(nodeRes))
+			}
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,54 @@
+package gpuschedulerplugin
+
+import (
+	"regexp"
+	"strconv"
+
+	types "github.com/Microsoft/KubeDevice-API/pkg/types"
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+	gputypes "github.com/Microsoft/KubeGPU/gpuplugintypes"
+)
+
+var gpuGroupRE = regexp.MustCompile(`^(.*/gpugrp0/[^/]+)/gpu/[^/]+/cards$`)
+
+var nicRequestRE = regexp.MustCompile(`/nic/[^/]+/count$`)
+
+// translateNICRequests asks for the RDMA NICs requested by the container in the gpugrp0 group of its first translated
+// GPU, so that the NICs are allocated next to the GPUs, the GPU requests must have been translated
+// NIC requests of an earlier translation, e.g. for another node, are replaced
+// containers without GPUs get no NICs, there is no GPU to place them by
+func translateNICRequests(cont *types.ContainerInfo) {
+	numNICs := cont.Requests[gputypes.ResourceRDMANIC]
+	if numNICs <= 0 {
+		return
+	}
+	for key := range cont.DevRequests {
+		if nicRequestRE.MatchString(string(key)) {
+			delete(cont.DevRequests, key)
+		}
+	}
+	group := ""
+	for _, key := range utils.SortedStringKeys(cont.DevRequests) {
+		if matches := gpuGroupRE.FindStringSubmatch(key); len(matches) >= 2 {
+			group = matches[1]
+			break
+		}
+	}
+	if group == "" {
+		utils.Logf(3, "Container requests %v RDMA NICs without GPUs, not translated", numNICs)
+		return
+	}
+	for i := int64(0); i < numNICs; i++ {
+		cont.DevRequests[types.ResourceName(group+"/nic/"+strconv.FormatInt(i, 10)+"/count")] = 1
+	}
+}
+
+// translatePodNICRequests translates the NIC requests of all containers of the pod
+func translatePodNICRequests(conts ...map[string]types.ContainerInfo) {
+	for _, c := range conts {
+		for name, cont := range c {
+			translateNICRequests(&cont)
+			c[name] = cont
+		}
+	}
+}
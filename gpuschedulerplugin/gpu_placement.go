@@ -0,0 +1,353 @@
+package gpuschedulerplugin
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/devicescheduler"
+	types "github.com/Microsoft/KubeDevice-API/pkg/types"
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+	gputypes "github.com/Microsoft/KubeGPU/gpuplugintypes"
+)
+
+// InvalidTopologyRequest is the predicate failure reason for pods with an unknown GPUTopologyGeneration request
+type InvalidTopologyRequest struct {
+	Value int64
+}
+
+func (r *InvalidTopologyRequest) GetReason() string {
+	return fmt.Sprintf("Invalid topology generation request %v", r.Value)
+}
+
+// Placement is a pod evaluated on a node, with the requests of its containers translated to GPU groups
+type Placement struct {
+	Node              string
+	Pod               string
+	InitContainers    map[string]types.ContainerInfo // translated containers, nil if the requests cannot be translated
+	RunningContainers map[string]types.ContainerInfo
+	Score             float64 // score of the candidate used, 0 without topology
+	TopologyMode      string
+	Tree              *gputypes.SortedTreeNode // tree of the free GPUs the candidates are enumerated on, nil without topology
+	Fits              bool
+	Reasons           []devicescheduler.PredicateFailureReason
+	Candidates        []Candidate // candidates enumerated on the tree, best first, the placement uses the first one
+}
+
+// podGPUs returns the GPUs the pod needs, the GPUs of the running containers summed up or of the largest init container,
+// and the GPUs of the running containers, SetGPUReqs must have been called on the containers
+func podGPUs(podInfo *types.PodInfo) (int, int) {
+	running := int64(0)
+	for _, cont := range podInfo.RunningContainers {
+		running += cont.Requests[gputypes.ResourceGPU]
+	}
+	numGPUs := running
+	for _, cont := range podInfo.InitContainers {
+		if cont.Requests[gputypes.ResourceGPU] > numGPUs {
+			numGPUs = cont.Requests[gputypes.ResourceGPU]
+		}
+	}
+	return int(numGPUs), int(running)
+}
+
+// InsufficientGPUs is the predicate failure reason for nodes with fewer free GPUs than the pod needs
+type InsufficientGPUs struct {
+	Required int
+	Free     int
+}
+
+func (r *InsufficientGPUs) GetReason() string {
+	return fmt.Sprintf("Insufficient GPUs, pod requires %v, node has %v free", r.Required, r.Free)
+}
+
+// nodeTree returns the tree of the free GPUs of the node by gpugrp1 and gpugrp0 group, built like the trees of the
+// node tree cache
+func nodeTree(nodeInfo *types.NodeInfo) *gputypes.SortedTreeNode {
+	free := make(types.ResourceList)
+	for res := range freeGPUs(nodeInfo) {
+		free[types.ResourceName(res)] = 1
+	}
+	return addToNode(nil, free, "gpugrp", "cards", 1)
+}
+
+// Evaluate evaluates the pod on the node with the default placement limit and scorer, see NvidiaGPUScheduler.Evaluate
+func Evaluate(nodeInfo *types.NodeInfo, podInfo *types.PodInfo) *Placement {
+	return (&NvidiaGPUScheduler{}).Evaluate(nodeInfo, podInfo)
+}
+
+// Evaluate checks the versions required by the pod and translates its GPU requests for the node, the node, the pod and
+// the node tree cache are not modified, the placement is written to the pod by Commit
+// with auto topology generation, the candidates on the tree of the free GPUs of the node are enumerated and the best
+// one is used
+func (ns *NvidiaGPUScheduler) Evaluate(nodeInfo *types.NodeInfo, podInfo *types.PodInfo) *Placement {
+	placement := &Placement{Node: nodeInfo.Name, Pod: podInfo.Name}
+	_, placement.Reasons = PodFitsVersions(nodeInfo, podInfo)
+	pod := copyPodInfo(podInfo)
+	for _, conts := range []map[string]types.ContainerInfo{pod.InitContainers, pod.RunningContainers} {
+		for name, cont := range conts {
+			SetGPUReqs(&cont)
+			conts[name] = cont
+		}
+	}
+	req, ok := pod.Requests[GPUTopologyGeneration]
+	switch {
+	case !ok || req == int64(1):
+		placement.TopologyMode = TopologyModeAuto
+		ns.placeOnTree(nodeTree(nodeInfo), pod, placement)
+	case req == int64(0):
+		placement.TopologyMode = TopologyModeNone
+		if err, found := TranslatePodGPUResources(nodeInfo, pod); err != nil || !found {
+			placement.Reasons = append(placement.Reasons, &InvalidTopologyRequest{Value: req})
+			break
+		}
+		translatePodNICRequests(pod.InitContainers, pod.RunningContainers)
+		placement.InitContainers = pod.InitContainers
+		placement.RunningContainers = pod.RunningContainers
+	default:
+		placement.TopologyMode = TopologyModeInvalid
+		placement.Reasons = append(placement.Reasons, &InvalidTopologyRequest{Value: req})
+	}
+	placement.Fits = len(placement.Reasons) == 0
+	return placement
+}
+
+// placeOnTree enumerates the candidates of the running containers on the tree and uses the best one,
+// init containers run one at a time and are translated to the tree like ConvertToBestGPURequests does
+func (ns *NvidiaGPUScheduler) placeOnTree(tree *gputypes.SortedTreeNode, pod *types.PodInfo, placement *Placement) {
+	limit := ns.PlacementLimit
+	if limit <= 0 {
+		limit = defaultPlacementLimit
+	}
+	scorer := ns.Scorer
+	if scorer == nil {
+		scorer = ScoreTree
+	}
+	placement.Tree = tree
+	numGPUs, running := podGPUs(pod)
+	if tree.Val < numGPUs {
+		placement.Reasons = append(placement.Reasons, &InsufficientGPUs{Required: numGPUs, Free: tree.Val})
+		return
+	}
+	for _, groups := range groupDistributions(tree, running, limit) {
+		conts := copyContainers(pod.RunningContainers)
+		translateToGroups(groups, conts)
+		translatePodNICRequests(conts)
+		placement.Candidates = append(placement.Candidates, Candidate{Groups: groups, RunningContainers: conts, Score: scorer(tree, groups)})
+	}
+	sort.SliceStable(placement.Candidates, func(i, j int) bool { return placement.Candidates[i].Score > placement.Candidates[j].Score })
+	utils.Logf(5, "Candidates on tree: %+v", placement.Candidates)
+	if len(placement.Candidates) == 0 {
+		placement.Reasons = append(placement.Reasons, &InsufficientGPUs{Required: numGPUs, Free: tree.Val})
+		return
+	}
+	placement.RunningContainers = placement.Candidates[0].RunningContainers
+	placement.Score = placement.Candidates[0].Score
+	for _, contKey := range utils.SortedStringKeys(pod.InitContainers) {
+		contCopy := pod.InitContainers[contKey]
+		translateToTree(tree, &contCopy)
+		translateNICRequests(&contCopy)
+		pod.InitContainers[contKey] = contCopy
+	}
+	placement.InitContainers = pod.InitContainers
+}
+
+// commitContainers writes the translated requests to the containers, which must all exist
+func commitContainers(podName string, placed map[string]types.ContainerInfo, conts map[string]types.ContainerInfo) error {
+	for name := range placed {
+		if _, ok := conts[name]; !ok {
+			return fmt.Errorf("pod %v has no container %v", podName, name)
+		}
+	}
+	for name, cont := range placed {
+		contCopy := conts[name]
+		contCopy.Requests = copyResourceList(cont.Requests)
+		contCopy.DevRequests = copyResourceList(cont.DevRequests)
+		conts[name] = contCopy
+	}
+	return nil
+}
+
+// Commit writes the translated requests of the placement to the pod it was evaluated for
+func (p *Placement) Commit(podInfo *types.PodInfo) error {
+	if !p.Fits {
+		return fmt.Errorf("%v", p.Reasons[0].GetReason())
+	}
+	if podInfo.Name != p.Pod {
+		return fmt.Errorf("placement of pod %v cannot be committed to pod %v", p.Pod, podInfo.Name)
+	}
+	if err := commitContainers(podInfo.Name, p.RunningContainers, podInfo.RunningContainers); err != nil {
+		return err
+	}
+	return commitContainers(podInfo.Name, p.InitContainers, podInfo.InitContainers)
+}
+
+// PlacementScorer scores the GPUs a candidate takes from each gpugrp0 group of each gpugrp1 group of the tree,
+// higher is better
+type PlacementScorer func(tree *gputypes.SortedTreeNode, groups [][]int) float64
+
+// ScoreTree scores a candidate like the trees of the node tree cache are scored, by computeTreeScore of the tree of the
+// GPUs it takes, candidates taking their GPUs from fewer groups score higher
+func ScoreTree(tree *gputypes.SortedTreeNode, groups [][]int) float64 {
+	taken := &gputypes.SortedTreeNode{}
+	for _, grp0s := range groups {
+		grp1 := &gputypes.SortedTreeNode{}
+		for _, num := range grp0s {
+			if num > 0 {
+				grp1.Val += num
+				gputypes.AddNodeToSortedTreeNode(grp1, &gputypes.SortedTreeNode{Val: num})
+			}
+		}
+		if grp1.Val > 0 {
+			grp1.Score = computeTreeScore(grp1)
+			taken.Val += grp1.Val
+			gputypes.AddNodeToSortedTreeNode(taken, grp1)
+		}
+	}
+	if taken.Val == 0 {
+		return 0.0
+	}
+	return computeTreeScore(taken)
+}
+
+// default number of candidates enumerated per pod and node
+const defaultPlacementLimit = 16
+
+// Candidate is a distribution of the GPUs of the running containers of a pod over the groups of a tree
+type Candidate struct {
+	Groups            [][]int // GPUs taken from each gpugrp0 child of each gpugrp1 child of the tree, in tree order
+	RunningContainers map[string]types.ContainerInfo
+	Score             float64
+}
+
+// lexGreater returns true if a is lexicographically greater than b
+func lexGreater(a []int, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+	return len(a) > len(b)
+}
+
+func capacity(nodes []*gputypes.SortedTreeNode) int {
+	total := 0
+	for _, node := range nodes {
+		total += node.Val
+	}
+	return total
+}
+
+func min(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+// leafDistributions returns the distinct ways of taking num GPUs from the gpugrp0 groups, taking most from the first
+// groups first, identical consecutive groups are interchangeable, so they take non-increasing numbers of GPUs
+func leafDistributions(leaves []*gputypes.SortedTreeNode, num int) [][]int {
+	dists := [][]int{}
+	var take func(i int, left int, dist []int)
+	take = func(i int, left int, dist []int) {
+		if i == len(leaves) {
+			if left == 0 {
+				dists = append(dists, append([]int{}, dist...))
+			}
+			return
+		}
+		if left > capacity(leaves[i:]) {
+			return
+		}
+		maxTake := min(leaves[i].Val, left)
+		if i > 0 && gputypes.CompareTreeNode(leaves[i], leaves[i-1]) {
+			maxTake = min(maxTake, dist[i-1])
+		}
+		for n := maxTake; n >= 0; n-- {
+			take(i+1, left-n, append(dist, n))
+		}
+	}
+	take(0, num, []int{})
+	return dists
+}
+
+// groupDistributions returns up to limit distinct ways of taking num GPUs from the gpugrp0 groups of the gpugrp1 groups
+// of the tree, the most packed first, identical consecutive gpugrp1 groups are interchangeable, so the number of GPUs
+// taken from them followed by their distributions must not increase lexicographically
+func groupDistributions(tree *gputypes.SortedTreeNode, num int, limit int) [][][]int {
+	children := tree.Child
+	results := [][][]int{}
+	var take func(i int, left int, groups [][]int) bool
+	take = func(i int, left int, groups [][]int) bool {
+		if i == len(children) {
+			if left == 0 {
+				results = append(results, append([][]int{}, groups...))
+			}
+			return len(results) < limit
+		}
+		if left > capacity(children[i:]) {
+			return true
+		}
+		for n := min(children[i].Val, left); n >= 0; n-- {
+			for _, dist := range leafDistributions(children[i].Child, n) {
+				if i > 0 && gputypes.CompareTreeNode(children[i], children[i-1]) &&
+					lexGreater(append([]int{n}, dist...), append([]int{sumInts(groups[i-1])}, groups[i-1]...)) {
+					continue
+				}
+				if !take(i+1, left-n, append(groups, dist)) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if limit > 0 {
+		take(0, num, [][]int{})
+	}
+	return results
+}
+
+func sumInts(vals []int) int {
+	total := 0
+	for _, val := range vals {
+		total += val
+	}
+	return total
+}
+
+// translateToGroups translates the GPU requests of the running containers to the groups of a candidate, the containers
+// take GPUs from the groups in tree order, in the order of their names
+// groups and the GPUs in them are numbered pod-wide, a group has the same name in all containers and a container taking
+// GPUs from a group some other container already took from continues with the next GPU index, so that containers
+// sharing a physical group share its name but not its GPUs
+func translateToGroups(groups [][]int, conts map[string]types.ContainerInfo) {
+	remaining := make([][]int, len(groups))
+	for i := range groups {
+		remaining[i] = append([]int{}, groups[i]...)
+	}
+	re := regexp.MustCompile(`.*/gpu/.*`)
+	for _, contKey := range utils.SortedStringKeys(conts) {
+		cont := conts[contKey]
+		newRequests := make(types.ResourceList)
+		for reqKey, reqVal := range cont.DevRequests {
+			if !re.MatchString(string(reqKey)) {
+				newRequests[reqKey] = reqVal
+			}
+		}
+		numLeft := int(cont.Requests[gputypes.ResourceGPU])
+		for i := range remaining {
+			for j := range remaining[i] {
+				toTake := min(remaining[i][j], numLeft)
+				taken := groups[i][j] - remaining[i][j]
+				for k := taken; k < taken+toTake; k++ {
+					types.AddGroupResource(newRequests, "gpugrp1/"+strconv.Itoa(i)+"/gpugrp0/"+strconv.Itoa(j)+"/gpu/"+strconv.Itoa(k)+"/cards", 1)
+				}
+				remaining[i][j] -= toTake
+				numLeft -= toTake
+			}
+		}
+		cont.DevRequests = newRequests
+		conts[contKey] = cont
+	}
+}
@@ -15,14 +15,26 @@ const (
 )
 
 type NvidiaGPUScheduler struct {
+	// maximum number of candidates enumerated per pod and node, 16 if not set
+	PlacementLimit int
+	// scores the candidates, ScoreTree if not set
+	Scorer PlacementScorer
+	// TakePodResources and ReturnPodResources account the GPUs the containers are allocated from in the used resources
+	// of the node, for callers without a group scheduler, which accounts them otherwise
+	AccountGPUs bool
+}
+
+// nodeGPUResources translates the allocatable resources of a node to two levels of GPU groups
+// alloc is modified, pass a copy to keep the node info unchanged
+func nodeGPUResources(nodeInfo *types.NodeInfo, alloc types.ResourceList) types.ResourceList {
+	return TranslateGPUResources(nodeInfo.KubeAlloc[gtype.ResourceGPU], types.ResourceList{
+		types.DeviceGroupPrefix + "/gpugrp1/A/gpugrp0/B/gpu/GPU0/cards": int64(1),
+	}, alloc)
 }
 
 // force translation to two levels
 func (ns *NvidiaGPUScheduler) AddNode(nodeName string, nodeInfo *types.NodeInfo) {
-	modReq := TranslateGPUResources(nodeInfo.KubeAlloc[gtype.ResourceGPU], types.ResourceList{
-		types.DeviceGroupPrefix + "/gpugrp1/A/gpugrp0/B/gpu/GPU0/cards": int64(1),
-	}, nodeInfo.Allocatable)
-	nodeInfo.Allocatable = modReq
+	nodeInfo.Allocatable = nodeGPUResources(nodeInfo, nodeInfo.Allocatable)
 	utils.Logf(4, "AllocAddNode: %v", nodeInfo.Allocatable)
 	AddResourcesToNodeTreeCache(nodeName, nodeInfo.Allocatable)
 }
@@ -31,34 +43,64 @@ func (ns *NvidiaGPUScheduler) RemoveNode(nodeName string) {
 	RemoveNodeFromNodeTreeCache(nodeName)
 }
 
+// PodFitsDevice evaluates the pod on the node, the pod is not modified so that the result does not depend on the
+// order in which nodes are evaluated, the placement is committed by PodAllocate on the chosen node
+// the score is that of the placement, higher is better, 0 without auto topology generation
 func (ns *NvidiaGPUScheduler) PodFitsDevice(nodeInfo *types.NodeInfo, podInfo *types.PodInfo, fillAllocateFrom bool) (bool, []devicescheduler.PredicateFailureReason, float64) {
-	err, found := TranslatePodGPUResources(nodeInfo, podInfo)
-	if err != nil {
-		//panic("Unexpected error")
-		return false, nil, 0.0
+	placement := ns.Evaluate(nodeInfo, podInfo)
+	if !placement.Fits {
+		return false, placement.Reasons, 0.0
 	}
-	if !found {
-		return false, nil, 0.0
-	}
-	return true, nil, 0.0
+	return true, nil, placement.Score
 }
 
+// PodAllocate evaluates the pod on the node and commits the placement to the pod
 func (ns *NvidiaGPUScheduler) PodAllocate(nodeInfo *types.NodeInfo, podInfo *types.PodInfo) error {
-	err, found := TranslatePodGPUResources(nodeInfo, podInfo)
-	if err != nil {
-		return err
-	}
-	if !found {
-		return fmt.Errorf("TranslatePodGPUResources fails as no translation is found")
+	return ns.Evaluate(nodeInfo, podInfo).Commit(podInfo)
+}
+
+// allocatedGPUs returns the GPU cards of the node the containers of the pod are allocated from, init containers run
+// before the running containers and may be allocated from the same GPUs
+func allocatedGPUs(podInfo *types.PodInfo) map[types.ResourceName]bool {
+	gpus := make(map[types.ResourceName]bool)
+	for _, conts := range []map[string]types.ContainerInfo{podInfo.InitContainers, podInfo.RunningContainers} {
+		for _, cont := range conts {
+			for _, nodeRes := range cont.AllocateFrom {
+				if gpuCardsRE.MatchString(string(nodeRes)) {
+					gpus[nodeRes] = true
+				}
+			}
+		}
 	}
-	return nil
+	return gpus
 }
 
+// TakePodResources marks the GPUs the pod is allocated from as used on the node if AccountGPUs is set, none are taken
+// if any of them is used already
 func (ns *NvidiaGPUScheduler) TakePodResources(nodeInfo *types.NodeInfo, podInfo *types.PodInfo) error {
+	if !ns.AccountGPUs {
+		return nil
+	}
+	gpus := allocatedGPUs(podInfo)
+	for gpu := range gpus {
+		if nodeInfo.Used[gpu] > 0 {
+			return fmt.Errorf("GPU %v of node %v allocated to pod %v is already used", gpu, nodeInfo.Name, podInfo.Name)
+		}
+	}
+	for gpu := range gpus {
+		nodeInfo.Used[gpu] = 1
+	}
 	return nil
 }
 
+// ReturnPodResources marks the GPUs the pod is allocated from as free on the node if AccountGPUs is set
 func (ns *NvidiaGPUScheduler) ReturnPodResources(nodeInfo *types.NodeInfo, podInfo *types.PodInfo) error {
+	if !ns.AccountGPUs {
+		return nil
+	}
+	for gpu := range allocatedGPUs(podInfo) {
+		delete(nodeInfo.Used, gpu)
+	}
 	return nil
 }
 
@@ -3,6 +3,7 @@ package gpuschedulerplugin
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	types "github.com/Microsoft/KubeDevice-API/pkg/types"
@@ -44,14 +45,14 @@ func TestTree(t *testing.T) {
 	AddResourcesToNodeTreeCache("B", nodeRes2)
 	AddResourcesToNodeTreeCache("C", nodeRes3)
 	AddResourcesToNodeTreeCache("D", types.ResourceList{"ABCD": 4})
-	for key, val := range nodeCacheMap {
+	for key, val := range NodeCacheMap {
 		fmt.Printf("Key\n")
 		sctypes.PrintTreeNode(key)
 		fmt.Printf("Val: %v\n", val)
 	}
 	RemoveNodeFromNodeTreeCache("A")
 	fmt.Printf("After removal\n")
-	for key, val := range nodeCacheMap {
+	for key, val := range NodeCacheMap {
 		fmt.Printf("Key\n")
 		sctypes.PrintTreeNode(key)
 		fmt.Printf("Val: %v\n", val)
@@ -88,12 +89,12 @@ func TestTree(t *testing.T) {
 	}
 	RemoveNodeFromNodeTreeCache("B")
 	fmt.Printf("Now should have only one\n")
-	for key, val := range nodeCacheMap {
+	for key, val := range NodeCacheMap {
 		fmt.Printf("Key\n")
 		sctypes.PrintTreeNode(key)
 		fmt.Printf("Val: %v\n", val)
 	}
-	fmt.Printf("LocationMap :%v\n", nodeLocationMap)
+	fmt.Printf("LocationMap :%v\n", NodeLocationMap)
 	ConvertToBestGPURequests(podInfo)
 	expectedPodInfo = &types.PodInfo{
 		RunningContainers: map[string]types.ContainerInfo{
@@ -111,3 +112,487 @@ func TestTree(t *testing.T) {
 		t.Errorf("Pod B not equal\nHave:\n%+v\nExpect:\n%+v", podInfo, expectedPodInfo)
 	}
 }
+
+func TestVersionConstraints(t *testing.T) {
+	nodeInfo := types.NewNodeInfo()
+	nodeInfo.Capacity[gputypes.AttributeDriverVersion] = 418087001
+	nodeInfo.Capacity[gputypes.AttributeCUDAVersion] = 10010
+	ns := &NvidiaGPUScheduler{}
+	podInfo := func(minDriver string, minCUDA string) *types.PodInfo {
+		pod := &types.PodInfo{Name: "pod", Requests: make(types.ResourceList), RunningContainers: map[string]types.ContainerInfo{}}
+		pod.Requests[GPUTopologyGeneration] = 0
+		if minDriver != "" {
+			pod.Requests[gputypes.ResourceMinDriverVersion], _ = gputypes.EncodeDriverVersion(minDriver)
+		}
+		if minCUDA != "" {
+			pod.Requests[gputypes.ResourceMinCUDAVersion], _ = gputypes.EncodeCUDAVersion(minCUDA)
+		}
+		return pod
+	}
+
+	for _, ok := range [][2]string{{"", ""}, {"418.87.01", "10.1"}, {"410.48", "9.0"}} {
+		if fits, reasons, _ := ns.PodFitsDevice(nodeInfo, podInfo(ok[0], ok[1]), false); !fits {
+			t.Errorf("Pod requiring %v should fit, have %v", ok, reasons)
+		}
+	}
+	fits, reasons, _ := ns.PodFitsDevice(nodeInfo, podInfo("525.60.13", "12.0"), false)
+	if fits || len(reasons) != 2 {
+		t.Fatalf("Pod requiring newer versions should not fit, have %v", reasons)
+	}
+	if _, ok := reasons[0].(*InsufficientVersion); !ok {
+		t.Errorf("Expected an InsufficientVersion reason, have %T", reasons[0])
+	}
+	expected := "Insufficient driver version, pod requires 525.60.13, node has 418.87.01"
+	if reasons[0].GetReason() != expected {
+		t.Errorf("Expected reason %v, have %v", expected, reasons[0].GetReason())
+	}
+	expected = "Insufficient CUDA version, pod requires 12.0, node has 10.1"
+	if reasons[1].GetReason() != expected {
+		t.Errorf("Expected reason %v, have %v", expected, reasons[1].GetReason())
+	}
+	if err := ns.PodAllocate(nodeInfo, podInfo("", "12.0")); err == nil {
+		t.Errorf("Expected allocation to fail")
+	}
+
+	// nodes which do not advertise a version do not fit pods requiring one
+	delete(nodeInfo.Capacity, gputypes.AttributeCUDAVersion)
+	fits, reasons, _ = ns.PodFitsDevice(nodeInfo, podInfo("", "9.0"), false)
+	if fits || len(reasons) != 1 || !strings.Contains(reasons[0].GetReason(), "unknown") {
+		t.Errorf("Pod should not fit node without CUDA version, have %v", reasons)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	for nodeName := range NodeLocationMap {
+		RemoveNodeFromNodeTreeCache(nodeName)
+	}
+	nodeInfo := types.NewNodeInfo()
+	nodeInfo.Name = "n1"
+	nodeInfo.KubeAlloc[gputypes.ResourceGPU] = 6
+	for i, group := range []string{"A/gpugrp0/0", "A/gpugrp0/0", "A/gpugrp0/1", "A/gpugrp0/1", "B/gpugrp0/2", "B/gpugrp0/2"} {
+		nodeInfo.Allocatable[types.ResourceName(fmt.Sprintf("resource/group/gpugrp1/%v/gpu/G%d/cards", group, i))] = 1
+	}
+	nodeInfo.Used["resource/group/gpugrp1/A/gpugrp0/0/gpu/G0/cards"] = 1
+	ns := &NvidiaGPUScheduler{}
+	cached := types.NewNodeInfo()
+	cached.KubeAlloc = nodeInfo.KubeAlloc
+	for key, val := range nodeInfo.Allocatable {
+		cached.Allocatable[key] = val
+	}
+	ns.AddNode("n1", cached)
+	defer ns.RemoveNode("n1")
+
+	podInfo := func(numGPUs int64) *types.PodInfo {
+		return &types.PodInfo{
+			Name:     "pod",
+			Requests: types.ResourceList{},
+			RunningContainers: map[string]types.ContainerInfo{
+				"main": {KubeRequests: types.ResourceList{gputypes.ResourceGPU: numGPUs}, Requests: types.ResourceList{}, DevRequests: types.ResourceList{}},
+			},
+		}
+	}
+
+	// two GPUs fit in the group with the fewest free GPUs
+	pod := podInfo(2)
+	exp := Explain(nodeInfo, pod)
+	if !exp.Fits || exp.NumGPUs != 2 || exp.TopologyMode != TopologyModeAuto || len(exp.Placements) == 0 {
+		t.Fatalf("Unexpected explanation %v", exp)
+	}
+	expectedFrom := types.ResourceLocation{
+		"resource/group/gpugrp1/0/gpugrp0/0/gpu/0/cards": "resource/group/gpugrp1/B/gpugrp0/2/gpu/G4/cards",
+		"resource/group/gpugrp1/0/gpugrp0/0/gpu/1/cards": "resource/group/gpugrp1/B/gpugrp0/2/gpu/G5/cards",
+	}
+	if !reflect.DeepEqual(exp.Containers[0].AllocateFrom, expectedFrom) {
+		t.Errorf("Expected %v, have %v", expectedFrom, exp.Containers[0].AllocateFrom)
+	}
+	if treeString(exp.Tree) != "5[3[2 1] 2[2]]" || exp.FreeGPUs["gpugrp1/A/gpugrp0/0"] != 1 {
+		t.Errorf("Unexpected tree %v or free GPUs %v", treeString(exp.Tree), exp.FreeGPUs)
+	}
+	// the explanation uses the placement the scheduler evaluates
+	if placement := ns.Evaluate(nodeInfo, pod); !reflect.DeepEqual(exp.Placements, placement.Candidates) ||
+		!reflect.DeepEqual(exp.Containers[0].DevRequests, placement.RunningContainers["main"].DevRequests) {
+		t.Errorf("Explanation %v differs from placement %+v", exp, placement)
+	}
+	if !reflect.DeepEqual(pod, podInfo(2)) || len(nodeInfo.Allocatable) != 6 {
+		t.Errorf("Explain modified its inputs")
+	}
+	if !strings.Contains(exp.String(), "Result: fits") {
+		t.Errorf("Unexpected explanation %v", exp)
+	}
+
+	// five GPUs are placed on the free GPUs, although a GPU of group A is used
+	exp = Explain(nodeInfo, podInfo(5))
+	if !exp.Fits || len(exp.Containers[0].AllocateFrom) != 5 {
+		t.Errorf("Unexpected explanation %v", exp)
+	}
+
+	// GPUs used after the pod was allocated are reported by the matching
+	pod = podInfo(4)
+	if err := ns.PodAllocate(nodeInfo, pod); err != nil {
+		t.Fatalf("PodAllocate fails %v", err)
+	}
+	nodeInfo.Used["resource/group/gpugrp1/B/gpugrp0/2/gpu/G4/cards"] = 1
+	matches, failure := MatchPod(nodeInfo, pod)
+	if failure == nil || failure.Container != "main" || len(matches) != 0 {
+		t.Errorf("Expected matching to fail, have %v %v", matches, failure)
+	}
+	delete(nodeInfo.Used, "resource/group/gpugrp1/B/gpugrp0/2/gpu/G4/cards")
+
+	// the node does not have seven free GPUs
+	exp = Explain(nodeInfo, podInfo(7))
+	expected := "topology: Insufficient GPUs, pod requires 7, node has 5 free"
+	if exp.Fits || len(exp.Placements) != 0 || treeString(exp.Tree) != "5[3[2 1] 2[2]]" || exp.Failure != expected {
+		t.Errorf("Expected failure %v, have %v", expected, exp)
+	}
+
+	pod = podInfo(1)
+	pod.Requests[GPUTopologyGeneration] = 2
+	exp = Explain(nodeInfo, pod)
+	if exp.Fits || exp.TopologyMode != TopologyModeInvalid || !strings.HasPrefix(exp.Failure, "topology:") {
+		t.Errorf("Unexpected explanation %v", exp)
+	}
+
+	pod = podInfo(1)
+	pod.Requests[gputypes.ResourceMinCUDAVersion] = 10010
+	exp = Explain(nodeInfo, pod)
+	if exp.Fits || len(exp.VersionReasons) != 1 || !strings.HasPrefix(exp.Failure, "versions:") {
+		t.Errorf("Unexpected explanation %v", exp)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	for nodeName := range NodeLocationMap {
+		RemoveNodeFromNodeTreeCache(nodeName)
+	}
+	ns := &NvidiaGPUScheduler{}
+	nodes := []*types.NodeInfo{}
+	for _, nodeName := range []string{"n1", "n2"} {
+		nodeInfo := types.NewNodeInfo()
+		nodeInfo.Name = nodeName
+		nodeInfo.KubeAlloc[gputypes.ResourceGPU] = 4
+		for i := 0; i < 4; i++ {
+			nodeInfo.Allocatable[types.ResourceName(fmt.Sprintf("resource/group/gpugrp1/A/gpugrp0/%d/gpu/G%d/cards", i/2, i))] = 1
+		}
+		ns.AddNode(nodeName, nodeInfo)
+		defer ns.RemoveNode(nodeName)
+		nodes = append(nodes, nodeInfo)
+	}
+	podInfo := func() *types.PodInfo {
+		return &types.PodInfo{
+			Name:     "pod",
+			Requests: types.ResourceList{},
+			RunningContainers: map[string]types.ContainerInfo{
+				"main": {KubeRequests: types.ResourceList{gputypes.ResourceGPU: 2}, Requests: types.ResourceList{}, DevRequests: types.ResourceList{}},
+			},
+			InitContainers: map[string]types.ContainerInfo{},
+		}
+	}
+
+	// evaluating the pod on several nodes leaves it unchanged and gives the same placement on identical nodes
+	pod := podInfo()
+	placements := []*Placement{}
+	for _, nodeInfo := range nodes {
+		placements = append(placements, Evaluate(nodeInfo, pod))
+	}
+	if !reflect.DeepEqual(pod, podInfo()) {
+		t.Errorf("Evaluation modified the pod, have %+v", pod)
+	}
+	if !reflect.DeepEqual(placements[0].RunningContainers, placements[1].RunningContainers) || placements[0].Score != placements[1].Score {
+		t.Errorf("Placements differ %+v %+v", placements[0], placements[1])
+	}
+	expected := types.ResourceList{
+		"resource/group/gpugrp1/0/gpugrp0/0/gpu/0/cards": 1,
+		"resource/group/gpugrp1/0/gpugrp0/0/gpu/1/cards": 1,
+	}
+	if !reflect.DeepEqual(placements[1].RunningContainers["main"].DevRequests, expected) || placements[1].Node != "n2" {
+		t.Errorf("Unexpected placement %+v", placements[1])
+	}
+
+	// committing writes the translated requests to the pod
+	other := podInfo()
+	other.Name = "other"
+	if err := placements[1].Commit(other); err == nil {
+		t.Errorf("Expected error committing the placement to another pod")
+	}
+	if err := placements[1].Commit(pod); err != nil {
+		t.Fatalf("Commit fails %v", err)
+	}
+	if !reflect.DeepEqual(pod.RunningContainers["main"].DevRequests, expected) || pod.RunningContainers["main"].Requests[gputypes.ResourceGPU] != 2 {
+		t.Errorf("Unexpected pod after commit %+v", pod)
+	}
+	allocated := podInfo()
+	if err := ns.PodAllocate(nodes[0], allocated); err != nil || !reflect.DeepEqual(allocated, pod) {
+		t.Errorf("PodAllocate should commit the same placement, have %v %+v", err, allocated)
+	}
+
+	// PodFitsDevice returns the score of the placement and leaves the pod unchanged on every node
+	fitted := podInfo()
+	for _, nodeInfo := range nodes {
+		fits, reasons, score := ns.PodFitsDevice(nodeInfo, fitted, false)
+		if !fits || len(reasons) != 0 || score != placements[1].Score {
+			t.Errorf("Pod should fit %v, have %v %v", nodeInfo.Name, reasons, score)
+		}
+	}
+	if !reflect.DeepEqual(fitted, podInfo()) {
+		t.Errorf("PodFitsDevice modified the pod, have %+v", fitted)
+	}
+	// also when the pod was translated before
+	for _, nodeInfo := range nodes {
+		if fits, _, score := ns.PodFitsDevice(nodeInfo, pod, false); !fits || score != placements[1].Score {
+			t.Errorf("Translated pod should fit %v, have %v", nodeInfo.Name, score)
+		}
+	}
+	if !reflect.DeepEqual(pod, allocated) {
+		t.Errorf("PodFitsDevice modified the translated pod, have %+v", pod)
+	}
+
+	// invalid topology requests are reported as reasons
+	pod = podInfo()
+	pod.Requests[GPUTopologyGeneration] = 3
+	fits, reasons, score := ns.PodFitsDevice(nodes[0], pod, false)
+	if fits || len(reasons) != 1 || reasons[0].GetReason() != "Invalid topology generation request 3" || score != 0.0 {
+		t.Errorf("Expected invalid topology request, have %v %v", reasons, score)
+	}
+	if !reflect.DeepEqual(pod.RunningContainers, podInfo().RunningContainers) {
+		t.Errorf("Pods which do not fit should not be modified, have %+v", pod)
+	}
+	if err := ns.PodAllocate(nodes[0], pod); err == nil {
+		t.Errorf("Expected allocation to fail")
+	}
+}
+
+func TestNICRequests(t *testing.T) {
+	for nodeName := range NodeLocationMap {
+		RemoveNodeFromNodeTreeCache(nodeName)
+	}
+	ns := &NvidiaGPUScheduler{}
+	nodeInfo := types.NewNodeInfo()
+	nodeInfo.Name = "n1"
+	nodeInfo.KubeAlloc[gputypes.ResourceGPU] = 4
+	for i := 0; i < 4; i++ {
+		nodeInfo.Allocatable[types.ResourceName(fmt.Sprintf("resource/group/gpugrp1/A/gpugrp0/%d/gpu/G%d/cards", i/2, i))] = 1
+	}
+	nodeInfo.Allocatable["resource/group/gpugrp1/A/gpugrp0/1/nic/mlx5_0/count"] = 1
+	ns.AddNode(nodeInfo.Name, nodeInfo)
+	defer ns.RemoveNode(nodeInfo.Name)
+	pod := &types.PodInfo{
+		Name:     "pod",
+		Requests: types.ResourceList{},
+		RunningContainers: map[string]types.ContainerInfo{
+			"main": {
+				KubeRequests: types.ResourceList{gputypes.ResourceGPU: 2},
+				Requests:     types.ResourceList{gputypes.ResourceRDMANIC: 1},
+				DevRequests:  types.ResourceList{},
+			},
+			"sidecar": {KubeRequests: types.ResourceList{}, Requests: types.ResourceList{gputypes.ResourceRDMANIC: 1}, DevRequests: types.ResourceList{}},
+		},
+	}
+
+	// the NIC is requested in the group of the GPUs, the container without GPUs gets none
+	placement := ns.Evaluate(nodeInfo, pod)
+	expected := types.ResourceList{
+		"resource/group/gpugrp1/0/gpugrp0/0/gpu/0/cards": 1,
+		"resource/group/gpugrp1/0/gpugrp0/0/gpu/1/cards": 1,
+		"resource/group/gpugrp1/0/gpugrp0/0/nic/0/count": 1,
+	}
+	if !placement.Fits || !reflect.DeepEqual(placement.RunningContainers["main"].DevRequests, expected) {
+		t.Errorf("Expected %v, have %+v", expected, placement)
+	}
+	if len(placement.RunningContainers["sidecar"].DevRequests) != 0 {
+		t.Errorf("Expected no requests for the sidecar, have %v", placement.RunningContainers["sidecar"].DevRequests)
+	}
+	for _, cand := range placement.Candidates {
+		if cand.RunningContainers["main"].DevRequests["resource/group/gpugrp1/0/gpugrp0/0/nic/0/count"] != 1 {
+			t.Errorf("Expected the NIC in every candidate, have %+v", cand)
+		}
+	}
+	// translating the committed pod again replaces the NIC requests
+	if err := ns.PodAllocate(nodeInfo, pod); err != nil {
+		t.Fatalf("PodAllocate fails %v", err)
+	}
+	if err := ns.PodAllocate(nodeInfo, pod); err != nil || !reflect.DeepEqual(pod.RunningContainers["main"].DevRequests, expected) {
+		t.Errorf("Expected %v, have %v %v", expected, err, pod.RunningContainers["main"].DevRequests)
+	}
+}
+
+func TestPlacements(t *testing.T) {
+	leaf := func() *gputypes.SortedTreeNode { return &gputypes.SortedTreeNode{Val: 2} }
+	tree := &gputypes.SortedTreeNode{Val: 4, Child: []*gputypes.SortedTreeNode{
+		{Val: 2, Child: []*gputypes.SortedTreeNode{leaf()}},
+		{Val: 2, Child: []*gputypes.SortedTreeNode{leaf()}},
+	}}
+	// the gpugrp1 groups are identical, so [[0] [2]] is the same as [[2] [0]]
+	expectedGroups := [][][]int{{{2}, {0}}, {{1}, {1}}}
+	if groups := groupDistributions(tree, 2, 16); !reflect.DeepEqual(groups, expectedGroups) {
+		t.Errorf("Expected %v, have %v", expectedGroups, groups)
+	}
+	if groups := groupDistributions(tree, 2, 1); !reflect.DeepEqual(groups, expectedGroups[:1]) {
+		t.Errorf("Expected %v, have %v", expectedGroups[:1], groups)
+	}
+	if groups := groupDistributions(tree, 5, 16); len(groups) != 0 {
+		t.Errorf("Expected no groups, have %v", groups)
+	}
+
+	for nodeName := range NodeLocationMap {
+		RemoveNodeFromNodeTreeCache(nodeName)
+	}
+	ns := &NvidiaGPUScheduler{}
+	nodes := []*types.NodeInfo{}
+	for nodeName, numGPUs := range map[string]int{"n1": 4, "n2": 8} {
+		nodeInfo := types.NewNodeInfo()
+		nodeInfo.Name = nodeName
+		nodeInfo.KubeAlloc[gputypes.ResourceGPU] = int64(numGPUs)
+		for i := 0; i < numGPUs; i++ {
+			nodeInfo.Allocatable[types.ResourceName(fmt.Sprintf("resource/group/gpugrp1/A/gpugrp0/%d/gpu/G%d/cards", i/2, i))] = 1
+		}
+		ns.AddNode(nodeName, nodeInfo)
+		defer ns.RemoveNode(nodeName)
+		if nodeName == "n1" {
+			nodes = append(nodes, nodeInfo)
+		}
+	}
+	pod := &types.PodInfo{
+		Name:     "pod",
+		Requests: types.ResourceList{},
+		RunningContainers: map[string]types.ContainerInfo{
+			"main": {KubeRequests: types.ResourceList{gputypes.ResourceGPU: 2}, Requests: types.ResourceList{}, DevRequests: types.ResourceList{}},
+		},
+	}
+
+	// the pod is placed on the tree of n1, not on the tree of n2 with the better score, pairs are preferred
+	placement := ns.Evaluate(nodes[0], pod)
+	if !placement.Fits || len(placement.Candidates) != 2 || placement.Score != 6.0 {
+		t.Fatalf("Unexpected placement %+v", placement)
+	}
+	if !reflect.DeepEqual(placement.Candidates[0].Groups, [][]int{{2, 0}}) || !reflect.DeepEqual(placement.Candidates[1].Groups, [][]int{{1, 1}}) {
+		t.Errorf("Unexpected candidates %+v", placement.Candidates)
+	}
+	if exp := Explain(nodes[0], pod); treeString(exp.Tree) != "4[4[2 2]]" || !reflect.DeepEqual(exp.Placements, placement.Candidates) {
+		t.Errorf("Unexpected explanation %v", exp)
+	}
+
+	// a scorer spreading the GPUs picks the other candidate
+	ns.Scorer = func(tree *gputypes.SortedTreeNode, groups [][]int) float64 { return -ScoreTree(tree, groups) }
+	placement = ns.Evaluate(nodes[0], pod)
+	expected := types.ResourceList{
+		"resource/group/gpugrp1/0/gpugrp0/0/gpu/0/cards": 1,
+		"resource/group/gpugrp1/0/gpugrp0/1/gpu/0/cards": 1,
+	}
+	if !reflect.DeepEqual(placement.RunningContainers["main"].DevRequests, expected) || placement.Score != -4.0 {
+		t.Errorf("Expected %v, have %+v", expected, placement)
+	}
+	if !reflect.DeepEqual(placement.Candidates[1].RunningContainers, Evaluate(nodes[0], pod).RunningContainers) {
+		t.Errorf("Candidates should keep the translated containers, have %+v", placement.Candidates[1])
+	}
+
+	ns.PlacementLimit = 1
+	if placement = ns.Evaluate(nodes[0], pod); len(placement.Candidates) != 1 {
+		t.Errorf("Expected one candidate, have %+v", placement.Candidates)
+	}
+
+	// candidates only take free GPUs
+	ns.PlacementLimit = 0
+	nodes[0].Used["resource/group/gpugrp1/A/gpugrp0/0/gpu/G0/cards"] = 1
+	nodes[0].Used["resource/group/gpugrp1/A/gpugrp0/1/gpu/G2/cards"] = 1
+	if placement = ns.Evaluate(nodes[0], pod); len(placement.Candidates) != 1 || !reflect.DeepEqual(placement.Candidates[0].Groups, [][]int{{1, 1}}) {
+		t.Errorf("Expected one candidate on the free GPUs, have %+v", placement.Candidates)
+	}
+	nodes[0].Used["resource/group/gpugrp1/A/gpugrp0/1/gpu/G3/cards"] = 1
+	if placement = ns.Evaluate(nodes[0], pod); placement.Fits || len(placement.Candidates) != 0 ||
+		placement.Reasons[0].GetReason() != "Insufficient GPUs, pod requires 2, node has 1 free" {
+		t.Errorf("Expected the pod not to fit, have %+v", placement)
+	}
+}
+
+func TestPodWideGroups(t *testing.T) {
+	conts := map[string]types.ContainerInfo{
+		"a": {Requests: types.ResourceList{gputypes.ResourceGPU: 2}, DevRequests: types.ResourceList{}},
+		"b": {Requests: types.ResourceList{gputypes.ResourceGPU: 3}, DevRequests: types.ResourceList{"resource/group/gpugrp1/0/gpugrp0/0/gpu/0/cards": 1}},
+	}
+	// b continues in the group a took from and takes the first GPU of the next group
+	translateToGroups([][]int{{4, 2}}, conts)
+	expected := map[string]types.ResourceList{
+		"a": {
+			"resource/group/gpugrp1/0/gpugrp0/0/gpu/0/cards": 1,
+			"resource/group/gpugrp1/0/gpugrp0/0/gpu/1/cards": 1,
+		},
+		"b": {
+			"resource/group/gpugrp1/0/gpugrp0/0/gpu/2/cards": 1,
+			"resource/group/gpugrp1/0/gpugrp0/0/gpu/3/cards": 1,
+			"resource/group/gpugrp1/0/gpugrp0/1/gpu/0/cards": 1,
+		},
+	}
+	for name, cont := range conts {
+		if !reflect.DeepEqual(cont.DevRequests, expected[name]) {
+			t.Errorf("Container %v, expected %v, have %v", name, expected[name], cont.DevRequests)
+		}
+	}
+
+	// placed on a node, no two containers of the pod request the same GPU
+	for nodeName := range NodeLocationMap {
+		RemoveNodeFromNodeTreeCache(nodeName)
+	}
+	ns := &NvidiaGPUScheduler{}
+	nodeInfo := types.NewNodeInfo()
+	nodeInfo.Name = "n1"
+	nodeInfo.KubeAlloc[gputypes.ResourceGPU] = 8
+	for i := 0; i < 8; i++ {
+		nodeInfo.Allocatable[types.ResourceName(fmt.Sprintf("resource/group/gpugrp1/%d/gpugrp0/%d/gpu/G%d/cards", i/4, i/2, i))] = 1
+	}
+	ns.AddNode(nodeInfo.Name, nodeInfo)
+	defer ns.RemoveNode(nodeInfo.Name)
+	pod := &types.PodInfo{
+		Name:     "pod",
+		Requests: types.ResourceList{},
+		RunningContainers: map[string]types.ContainerInfo{
+			"a": {KubeRequests: types.ResourceList{gputypes.ResourceGPU: 3}, Requests: types.ResourceList{}, DevRequests: types.ResourceList{}},
+			"b": {KubeRequests: types.ResourceList{gputypes.ResourceGPU: 3}, Requests: types.ResourceList{}, DevRequests: types.ResourceList{}},
+		},
+	}
+	if err := ns.PodAllocate(nodeInfo, pod); err != nil {
+		t.Fatalf("PodAllocate fails %v", err)
+	}
+	requested := make(map[types.ResourceName]string)
+	for name, cont := range pod.RunningContainers {
+		if len(cont.DevRequests) != 3 {
+			t.Errorf("Container %v, expected 3 GPUs, have %v", name, cont.DevRequests)
+		}
+		for res := range cont.DevRequests {
+			if other, ok := requested[res]; ok {
+				t.Errorf("Containers %v and %v both request %v", other, name, res)
+			}
+			requested[res] = name
+		}
+	}
+}
+
+func TestTakePodResources(t *testing.T) {
+	nodeInfo := types.NewNodeInfo()
+	nodeInfo.Name = "n1"
+	gpu := types.ResourceName("resource/group/gpugrp1/A/gpugrp0/0/gpu/G0/cards")
+	pod := &types.PodInfo{
+		Name: "pod",
+		RunningContainers: map[string]types.ContainerInfo{
+			"main": {AllocateFrom: types.ResourceLocation{"resource/group/gpugrp1/0/gpugrp0/0/gpu/0/cards": gpu, "resource/group/gpugrp1/0/gpugrp0/0/gpu/0/memory": "memory"}},
+		},
+		InitContainers: map[string]types.ContainerInfo{
+			"init": {AllocateFrom: types.ResourceLocation{"resource/group/gpugrp1/0/gpugrp0/0/gpu/0/cards": gpu}},
+		},
+	}
+	// the group scheduler accounts the GPUs
+	ns := &NvidiaGPUScheduler{}
+	if err := ns.TakePodResources(nodeInfo, pod); err != nil || len(nodeInfo.Used) != 0 {
+		t.Errorf("Expected no GPUs taken, have %v %v", err, nodeInfo.Used)
+	}
+
+	ns.AccountGPUs = true
+	if err := ns.TakePodResources(nodeInfo, pod); err != nil || !reflect.DeepEqual(nodeInfo.Used, types.ResourceList{gpu: 1}) {
+		t.Errorf("Expected %v taken, have %v %v", gpu, err, nodeInfo.Used)
+	}
+	if err := ns.TakePodResources(nodeInfo, pod); err == nil {
+		t.Errorf("Expected error taking a used GPU")
+	}
+	if err := ns.ReturnPodResources(nodeInfo, pod); err != nil || len(nodeInfo.Used) != 0 {
+		t.Errorf("Expected GPUs returned, have %v %v", err, nodeInfo.Used)
+	}
+}
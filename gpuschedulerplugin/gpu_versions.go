@@ -0,0 +1,54 @@
+package gpuschedulerplugin
+
+import (
+	"fmt"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/devicescheduler"
+	types "github.com/Microsoft/KubeDevice-API/pkg/types"
+	gputypes "github.com/Microsoft/KubeGPU/gpuplugintypes"
+)
+
+// InsufficientVersion is the predicate failure reason for nodes with a lower driver or CUDA version than the pod requires
+type InsufficientVersion struct {
+	Name      string // "driver" or "CUDA"
+	Required  string
+	Available string // empty if the node does not advertise the version
+}
+
+func (r *InsufficientVersion) GetReason() string {
+	if r.Available == "" {
+		return fmt.Sprintf("Insufficient %v version, pod requires %v, node version unknown", r.Name, r.Required)
+	}
+	return fmt.Sprintf("Insufficient %v version, pod requires %v, node has %v", r.Name, r.Required, r.Available)
+}
+
+type versionRequirement struct {
+	name      string
+	required  types.ResourceName
+	available types.ResourceName
+	decode    func(int64) string
+}
+
+var versionRequirements = []versionRequirement{
+	{"driver", gputypes.ResourceMinDriverVersion, gputypes.AttributeDriverVersion, gputypes.DecodeDriverVersion},
+	{"CUDA", gputypes.ResourceMinCUDAVersion, gputypes.AttributeCUDAVersion, gputypes.DecodeCUDAVersion},
+}
+
+// PodFitsVersions checks the minimum driver and CUDA version requests of the pod against the version attributes in the
+// capacity of the node
+func PodFitsVersions(nodeInfo *types.NodeInfo, podInfo *types.PodInfo) (bool, []devicescheduler.PredicateFailureReason) {
+	reasons := []devicescheduler.PredicateFailureReason{}
+	for _, req := range versionRequirements {
+		required, ok := podInfo.Requests[req.required]
+		if !ok || required <= 0 {
+			continue
+		}
+		available, ok := nodeInfo.Capacity[req.available]
+		if !ok {
+			reasons = append(reasons, &InsufficientVersion{Name: req.name, Required: req.decode(required)})
+		} else if available < required {
+			reasons = append(reasons, &InsufficientVersion{Name: req.name, Required: req.decode(required), Available: req.decode(available)})
+		}
+	}
+	return len(reasons) == 0, reasons
+}
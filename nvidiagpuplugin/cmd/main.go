@@ -1,26 +1,128 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 
 	devtypes "github.com/Microsoft/KubeDevice-API/pkg/device"
 	"github.com/Microsoft/KubeDevice-API/pkg/types"
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
 	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvidia"
 )
 
+func printResources(title string, list types.ResourceList) {
+	fmt.Printf("%v:\n", title)
+	for _, key := range utils.SortedStringKeys(list) {
+		fmt.Printf("  %v: %v\n", key, list[types.ResourceName(key)])
+	}
+}
+
+func printNodeInfo(nodeInfo *types.NodeInfo) {
+	printResources("Capacity", nodeInfo.Capacity)
+	printResources("Allocatable", nodeInfo.Allocatable)
+	printResources("KubeCap", nodeInfo.KubeCap)
+	printResources("KubeAlloc", nodeInfo.KubeAlloc)
+}
+
+// loadInventory creates a fake device from a GpusInfo JSON file, in the units reported by the nvidia docker plugin or
+// by NVML, as printed by nvmlinfo json
+func loadInventory(path string, policyPath string, attributes string) (devtypes.Device, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info nvgputypes.GpusInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("parsing %v fails: %v", path, err)
+	}
+	if nvidia.InNVMLUnits(&info) {
+		nvidia.ToPluginUnits(&info)
+	}
+	d, err := nvidia.NewFakeNvidiaGPUManager(&info, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if policyPath != "" {
+		policy, err := nvidia.LoadGroupingPolicy(policyPath)
+		if err != nil {
+			return nil, err
+		}
+		d.(*nvidia.NvidiaGPUManager).GroupingPolicy = policy
+	}
+	attrs, err := nvidia.ParseAttributes(attributes)
+	if err != nil {
+		return nil, err
+	}
+	d.(*nvidia.NvidiaGPUManager).AdvertisedAttributes = attrs
+	return d, nil
+}
+
+// simulateAllocate allocates the resources in the AllocateFrom JSON file, mapping requested to node resources
+func simulateAllocate(d devtypes.Device, path string) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+	if err := json.Unmarshal(body, &container.AllocateFrom); err != nil {
+		return fmt.Errorf("parsing %v fails: %v", path, err)
+	}
+	pod := &types.PodInfo{Name: "simulated", RunningContainers: map[string]types.ContainerInfo{"main": container}}
+	mounts, devices, env, err := d.Allocate(pod, &container)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Allocate:\n")
+	for _, mount := range mounts {
+		fmt.Printf("  Mount: %v -> %v (readonly %v)\n", mount.HostPath, mount.ContainerPath, mount.Readonly)
+	}
+	for _, device := range devices {
+		fmt.Printf("  Device: %v\n", device)
+	}
+	for _, key := range utils.SortedStringKeys(env) {
+		fmt.Printf("  Env: %v=%v\n", key, env[key])
+	}
+	return nil
+}
+
 func main() {
 	var usePlugin = flag.Bool("plugin", false, "Use plugin to find devices.")
+	var pluginPath = flag.String("plugin-path", "/usr/local/KubeExt/devices/nvidiagpuplugin.so", "Path of the device plugin used with -plugin.")
+	var inventory = flag.String("inventory", "", "GpusInfo JSON file to compute node resources from, instead of local devices, as printed by nvmlinfo json or in the nvidia docker plugin units.")
+	var policyPath = flag.String("grouping-policy", "", "Grouping policy file used with -inventory, the default policy is used if not set.")
+	var attributes = flag.String("attributes", "", "Comma separated GPU attributes to advertise with -inventory, e.g. power,compute-capability.")
+	var allocateFrom = flag.String("allocate-from", "", "JSON file with an AllocateFrom map to simulate Allocate with, used with -inventory.")
 	flag.Parse()
 
-	if !*usePlugin {
+	if *inventory != "" {
+		d, err := loadInventory(*inventory, *policyPath, *attributes)
+		if err != nil {
+			fmt.Printf("Loading inventory encounters error %v\n", err)
+			os.Exit(1)
+		}
+		nodeInfo := types.NewNodeInfo()
+		if err := d.UpdateNodeInfo(nodeInfo); err != nil {
+			fmt.Printf("UpdateNodeInfo encounters error %v\n", err)
+			os.Exit(1)
+		}
+		printNodeInfo(nodeInfo)
+		if *allocateFrom != "" {
+			if err := simulateAllocate(d, *allocateFrom); err != nil {
+				fmt.Printf("Allocate encounters error %v\n", err)
+				os.Exit(1)
+			}
+		}
+	} else if !*usePlugin {
 		fmt.Printf("Not using plugin\n")
-		devices, err := nvgputypes.GetDevices()
+		devices, err := nvgputypes.GetDevices(nvgputypes.DefaultSysfsRoot)
 		fmt.Printf("Err: %v Devices: %+v\n", err, devices)
 	} else {
 		fmt.Printf("Using plugin\n")
-		d, err := devtypes.CreateDeviceFromPlugin("/usr/local/KubeExt/devices/nvidiagpuplugin.so")
+		d, err := devtypes.CreateDeviceFromPlugin(*pluginPath)
 		if err != nil {
 			fmt.Printf("Error creating plugin - error encountered %v", err)
 		} else {
@@ -0,0 +1,290 @@
+// Package fixtures generates GpusInfo inventories of common server designs, in the units reported by the
+// nvidia docker plugin, for use with NewFakeNvidiaGPUManager, simulators and tests
+package fixtures
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+// Options customize a design, zero values select the defaults of the design
+type Options struct {
+	GPUs       int    // number of GPUs
+	Memory     int64  // MiB per GPU
+	Model      string // unknown models use the specifications of the default model
+	Driver     string
+	CUDA       string
+	MIGProfile string // MIG instance profile, e.g. 1g.5gb
+}
+
+// Model describes the specifications of a GPU model
+type Model struct {
+	Memory          int64 // MiB
+	Family          string
+	Arch            string
+	Cores           int64
+	Power           int64 // W
+	Clocks          nvgputypes.ClockInfo
+	Bandwidth       int64 // memory bandwidth in MB/s
+	NVLinkBandwidth int64 // per link and direction in bytes/s
+}
+
+// Models holds the specifications of the default models of the designs
+var Models = map[string]Model{
+	"Tesla V100-PCIE-16GB": {Memory: 16160, Family: "Volta", Arch: "7.0", Cores: 5120, Power: 250, Clocks: nvgputypes.ClockInfo{Cores: 1380, Memory: 877}, Bandwidth: 900000},
+	"Tesla V100-SXM2-16GB": {Memory: 16160, Family: "Volta", Arch: "7.0", Cores: 5120, Power: 300, Clocks: nvgputypes.ClockInfo{Cores: 1530, Memory: 877}, Bandwidth: 900000, NVLinkBandwidth: 25000000000},
+	"Tesla V100-SXM3-32GB": {Memory: 32480, Family: "Volta", Arch: "7.0", Cores: 5120, Power: 350, Clocks: nvgputypes.ClockInfo{Cores: 1597, Memory: 958}, Bandwidth: 980000, NVLinkBandwidth: 25000000000},
+	"Tesla T4":             {Memory: 15109, Family: "Turing", Arch: "7.5", Cores: 2560, Power: 70, Clocks: nvgputypes.ClockInfo{Cores: 1590, Memory: 5001}, Bandwidth: 320000},
+	"A100-SXM4-40GB":       {Memory: 40536, Family: "Ampere", Arch: "8.0", Cores: 6912, Power: 400, Clocks: nvgputypes.ClockInfo{Cores: 1410, Memory: 1215}, Bandwidth: 1555000, NVLinkBandwidth: 25000000000},
+}
+
+// MIGProfile describes a MIG instance profile of the A100 40GB
+type MIGProfile struct {
+	Instances int   // instances per GPU
+	Memory    int64 // MiB
+	Cores     int64
+}
+
+// MIGProfiles holds the supported MIG instance profiles
+var MIGProfiles = map[string]MIGProfile{
+	"1g.5gb":  {Instances: 7, Memory: 4864, Cores: 896},
+	"2g.10gb": {Instances: 3, Memory: 9984, Cores: 1792},
+	"3g.20gb": {Instances: 2, Memory: 20096, Cores: 2688},
+	"4g.20gb": {Instances: 1, Memory: 20096, Cores: 3584},
+	"7g.40gb": {Instances: 1, Memory: 40192, Cores: 6272},
+}
+
+// Designs maps design names to their generators
+var Designs = map[string]func(Options) (*nvgputypes.GpusInfo, error){
+	"pcie-dual-socket": PCIeDualSocket,
+	"dgx1":             DGX1,
+	"dgx2":             DGX2,
+	"single-switch":    SingleSwitch,
+	"cloud-vm":         CloudVM,
+	"mig-a100":         MIGA100,
+}
+
+// Generate returns the inventory of the named design
+func Generate(design string, opts Options) (*nvgputypes.GpusInfo, error) {
+	generate, ok := Designs[design]
+	if !ok {
+		names := []string{}
+		for name := range Designs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown design %v, known designs are %v", design, names)
+	}
+	return generate(opts)
+}
+
+// defaults fills in the options which are not set
+func (opts Options) defaults(gpus int, model string, driver string, cuda string) Options {
+	if opts.GPUs == 0 {
+		opts.GPUs = gpus
+	}
+	if opts.Model == "" {
+		opts.Model = model
+	}
+	if opts.Driver == "" {
+		opts.Driver = driver
+	}
+	if opts.CUDA == "" {
+		opts.CUDA = cuda
+	}
+	return opts
+}
+
+// newGpu creates GPU i with the specifications of the model in the options, or of the default model
+func newGpu(i int, busID string, socket int, opts Options, defaultModel string) nvgputypes.GpuInfo {
+	model, ok := Models[opts.Model]
+	if !ok {
+		model = Models[defaultModel]
+	}
+	memory := model.Memory
+	if opts.Memory != 0 {
+		memory = opts.Memory
+	}
+	gpu := nvgputypes.GpuInfo{
+		ID:     fmt.Sprintf("GPU-%08x-0000-0000-0000-%012x", i, i),
+		Model:  opts.Model,
+		Path:   "/dev/nvidia" + strconv.Itoa(i),
+		Power:  model.Power,
+		Clocks: model.Clocks,
+		Family: model.Family,
+		Arch:   model.Arch,
+		Cores:  model.Cores,
+		Memory: nvgputypes.MemoryInfo{Global: memory, Bandwidth: model.Bandwidth},
+		PCI:    nvgputypes.PciInfo{BusID: busID, Bandwidth: 15760},
+	}
+	if socket >= 0 {
+		affinity := int64(socket)
+		gpu.CPUAffinity = &affinity
+		node := int64(socket)
+		gpu.NUMANode = &node
+	}
+	return gpu
+}
+
+// pcieBusID returns the bus ID of GPU i on a dual socket PCIe design, GPUs come in pairs behind a PCIe switch
+func pcieBusID(i int, perSocket int) string {
+	socket, pair, member := i/perSocket, (i%perSocket)/2, i%2
+	return fmt.Sprintf("0000:%02X:00.0", socket*0x80+0x04+pair*4+member)
+}
+
+// pcieLinks sets the PCIe topology of a dual socket design, pairs share a PCIe switch, peers of one socket
+// share a host bridge and peers across sockets are not listed, like the nvidia docker plugin reports them
+func pcieLinks(gpus []nvgputypes.GpuInfo, perSocket int) {
+	for i := range gpus {
+		for j := range gpus {
+			if i == j || i/perSocket != j/perSocket {
+				continue
+			}
+			link := int32(3)
+			if (i%perSocket)/2 == (j%perSocket)/2 {
+				link = 5
+			}
+			gpus[i].Topology = append(gpus[i].Topology, nvgputypes.TopologyInfo{BusID: gpus[j].PCI.BusID, Link: link})
+		}
+	}
+}
+
+func addNVLink(gpus []nvgputypes.GpuInfo, i int, j int, links int32, opts Options, defaultModel string) {
+	model, ok := Models[opts.Model]
+	if !ok {
+		model = Models[defaultModel]
+	}
+	gpus[i].NVLinks = append(gpus[i].NVLinks, nvgputypes.NVLinkInfo{BusID: gpus[j].PCI.BusID, Links: links, Bandwidth: int64(links) * model.NVLinkBandwidth})
+	gpus[j].NVLinks = append(gpus[j].NVLinks, nvgputypes.NVLinkInfo{BusID: gpus[i].PCI.BusID, Links: links, Bandwidth: int64(links) * model.NVLinkBandwidth})
+}
+
+// dualSocket creates the GPUs of a dual socket PCIe design, half of the GPUs on each socket
+func dualSocket(opts Options, defaultModel string) ([]nvgputypes.GpuInfo, error) {
+	if opts.GPUs < 2 || opts.GPUs%4 != 0 || opts.GPUs > 64 {
+		return nil, fmt.Errorf("dual socket designs have a multiple of 4 GPUs up to 64, not %v", opts.GPUs)
+	}
+	perSocket := opts.GPUs / 2
+	gpus := []nvgputypes.GpuInfo{}
+	for i := 0; i < opts.GPUs; i++ {
+		gpus = append(gpus, newGpu(i, pcieBusID(i, perSocket), i/perSocket, opts, defaultModel))
+	}
+	pcieLinks(gpus, perSocket)
+	return gpus, nil
+}
+
+// PCIeDualSocket is a server with 8 PCIe GPUs by default, half on each socket and in pairs behind PCIe switches
+func PCIeDualSocket(opts Options) (*nvgputypes.GpusInfo, error) {
+	opts = opts.defaults(8, "Tesla V100-PCIE-16GB", "418.87.01", "10.1")
+	gpus, err := dualSocket(opts, "Tesla V100-PCIE-16GB")
+	if err != nil {
+		return nil, err
+	}
+	return &nvgputypes.GpusInfo{Version: nvgputypes.VersionInfo{Driver: opts.Driver, CUDA: opts.CUDA}, Gpus: gpus}, nil
+}
+
+// dgx1NVLinks are the NVLinks of the DGX-1 hybrid cube mesh, as pairs of GPUs and the number of links between them
+var dgx1NVLinks = [][3]int{
+	{0, 1, 1}, {0, 2, 1}, {0, 3, 2}, {0, 4, 2},
+	{1, 2, 2}, {1, 3, 1}, {1, 5, 2},
+	{2, 3, 2}, {2, 6, 1},
+	{3, 7, 1},
+	{4, 5, 1}, {4, 6, 1}, {4, 7, 2},
+	{5, 6, 2}, {5, 7, 1},
+	{6, 7, 2},
+}
+
+// DGX1 is a DGX-1 with 8 V100 GPUs in a hybrid cube mesh of NVLinks, on top of a dual socket PCIe design
+func DGX1(opts Options) (*nvgputypes.GpusInfo, error) {
+	opts = opts.defaults(8, "Tesla V100-SXM2-16GB", "418.87.01", "10.1")
+	if opts.GPUs != 8 {
+		return nil, fmt.Errorf("DGX-1 has 8 GPUs, not %v", opts.GPUs)
+	}
+	gpus, err := dualSocket(opts, "Tesla V100-SXM2-16GB")
+	if err != nil {
+		return nil, err
+	}
+	for _, nvlink := range dgx1NVLinks {
+		addNVLink(gpus, nvlink[0], nvlink[1], int32(nvlink[2]), opts, "Tesla V100-SXM2-16GB")
+	}
+	return &nvgputypes.GpusInfo{Version: nvgputypes.VersionInfo{Driver: opts.Driver, CUDA: opts.CUDA}, Gpus: gpus}, nil
+}
+
+// DGX2 is a DGX-2 with 16 V100 GPUs, all connected with 6 NVLinks through NVSwitches
+// other NVSwitch systems, such as HGX boards with 8 GPUs, are generated by setting the number of GPUs
+// as reported by NVML, the links go to the switches and no GPU is a direct NVLink peer of another
+func DGX2(opts Options) (*nvgputypes.GpusInfo, error) {
+	opts = opts.defaults(16, "Tesla V100-SXM3-32GB", "418.87.01", "10.1")
+	gpus, err := dualSocket(opts, "Tesla V100-SXM3-32GB")
+	if err != nil {
+		return nil, err
+	}
+	for i := range gpus {
+		gpus[i].NVSwitchLinks = 6
+	}
+	return &nvgputypes.GpusInfo{Version: nvgputypes.VersionInfo{Driver: opts.Driver, CUDA: opts.CUDA}, Gpus: gpus}, nil
+}
+
+// SingleSwitch is a server with 4 GPUs by default, all behind a single PCIe switch
+func SingleSwitch(opts Options) (*nvgputypes.GpusInfo, error) {
+	opts = opts.defaults(4, "Tesla T4", "418.87.01", "10.1")
+	if opts.GPUs < 1 || opts.GPUs > 16 {
+		return nil, fmt.Errorf("single switch designs have 1 to 16 GPUs, not %v", opts.GPUs)
+	}
+	gpus := []nvgputypes.GpuInfo{}
+	for i := 0; i < opts.GPUs; i++ {
+		gpus = append(gpus, newGpu(i, fmt.Sprintf("0000:%02X:00.0", 0x1A+i), 0, opts, "Tesla T4"))
+	}
+	for i := range gpus {
+		for j := range gpus {
+			if i != j {
+				gpus[i].Topology = append(gpus[i].Topology, nvgputypes.TopologyInfo{BusID: gpus[j].PCI.BusID, Link: 5})
+			}
+		}
+	}
+	return &nvgputypes.GpusInfo{Version: nvgputypes.VersionInfo{Driver: opts.Driver, CUDA: opts.CUDA}, Gpus: gpus}, nil
+}
+
+// CloudVM is a virtual machine with 4 GPUs by default passed through in their own PCI domains,
+// the topology between them is not known
+func CloudVM(opts Options) (*nvgputypes.GpusInfo, error) {
+	opts = opts.defaults(4, "Tesla V100-PCIE-16GB", "418.87.01", "10.1")
+	if opts.GPUs < 1 || opts.GPUs > 15 {
+		return nil, fmt.Errorf("cloud VMs have 1 to 15 GPUs, not %v", opts.GPUs)
+	}
+	gpus := []nvgputypes.GpuInfo{}
+	for i := 0; i < opts.GPUs; i++ {
+		gpus = append(gpus, newGpu(i, fmt.Sprintf("%04X:00:00.0", (i+1)*0x1000+0x0A5E), -1, opts, "Tesla V100-PCIE-16GB"))
+	}
+	return &nvgputypes.GpusInfo{Version: nvgputypes.VersionInfo{Driver: opts.Driver, CUDA: opts.CUDA}, Gpus: gpus}, nil
+}
+
+// MIGA100 is a server with one A100 by default, partitioned into MIG instances of the profile in the options (1g.5gb by default)
+// the plugin does not discover MIG instances and allocates whole GPUs, so the GPUs are whole GPU entries with the
+// partitioning in their MIG metadata
+// with more than one GPU, the GPUs are laid out like a dual socket PCIe design and do not use NVLink
+func MIGA100(opts Options) (*nvgputypes.GpusInfo, error) {
+	opts = opts.defaults(1, "A100-SXM4-40GB", "470.82.01", "11.4")
+	if opts.MIGProfile == "" {
+		opts.MIGProfile = "1g.5gb"
+	}
+	profile, ok := MIGProfiles[opts.MIGProfile]
+	if !ok {
+		return nil, fmt.Errorf("unknown MIG profile %v", opts.MIGProfile)
+	}
+	var gpus []nvgputypes.GpuInfo
+	if opts.GPUs == 1 {
+		gpus = []nvgputypes.GpuInfo{newGpu(0, "0000:07:00.0", 0, opts, "A100-SXM4-40GB")}
+	} else {
+		var err error
+		if gpus, err = dualSocket(opts, "A100-SXM4-40GB"); err != nil {
+			return nil, err
+		}
+	}
+	for i := range gpus {
+		gpus[i].MIG = &nvgputypes.MIGInfo{Profile: opts.MIGProfile, Instances: profile.Instances, Memory: profile.Memory, Cores: profile.Cores}
+	}
+	return &nvgputypes.GpusInfo{Version: nvgputypes.VersionInfo{Driver: opts.Driver, CUDA: opts.CUDA}, Gpus: gpus}, nil
+}
@@ -0,0 +1,59 @@
+package nvgputypes
+
+import (
+	"time"
+)
+
+type HealthEventType int
+
+const (
+	// HealthEventXid is a critical XID error, Data holds the XID
+	HealthEventXid HealthEventType = iota
+	// HealthEventDoubleBitECC is an uncorrectable (double bit) ECC error
+	HealthEventDoubleBitECC
+	// HealthEventRetiredPages reports the number of retired pages in Data
+	HealthEventRetiredPages
+	// HealthEventRetirementPending reports pages pending retirement, which is completed by resetting the device
+	HealthEventRetirementPending
+)
+
+func (t HealthEventType) String() string {
+	switch t {
+	case HealthEventXid:
+		return "XID"
+	case HealthEventDoubleBitECC:
+		return "DoubleBitECC"
+	case HealthEventRetiredPages:
+		return "RetiredPages"
+	case HealthEventRetirementPending:
+		return "RetirementPending"
+	}
+	return "Unknown"
+}
+
+type HealthEvent struct {
+	UUID string
+	Type HealthEventType
+	Data uint64
+}
+
+// HealthEventSource is a source of device health events, e.g. an NVML event set
+type HealthEventSource interface {
+	// Register starts watching the devices with the given UUIDs
+	Register(uuids []string) error
+	// Wait blocks for up to timeout for the next event, returns nil event on timeout
+	Wait(timeout time.Duration) (*HealthEvent, error)
+	Close()
+}
+
+// RetiredPages is the page retirement status of a device
+type RetiredPages struct {
+	Count   uint64 // pages retired due to single and double bit ECC errors
+	Pending bool   // pages are pending retirement
+}
+
+// RetiredPagesSource reports the page retirement status of devices, which NVML does not report as events
+type RetiredPagesSource interface {
+	// RetiredPages returns the status of all devices by UUID
+	RetiredPages() (map[string]RetiredPages, error)
+}
@@ -2,11 +2,21 @@ package nvgputypes
 
 import (
 	"encoding/json"
+	"fmt"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type MemoryInfo struct {
-	Global int64 `json:"Global"`
+	Global    int64 `json:"Global"`
+	Bandwidth int64 `json:"Bandwidth"`
+}
+
+type ClockInfo struct {
+	Cores  int64 `json:"Cores"`  // MHz
+	Memory int64 `json:"Memory"` // MHz
 }
 
 type PciInfo struct {
@@ -19,18 +29,55 @@ type TopologyInfo struct {
 	Link  int32  `json:"Link"`
 }
 
+// LinkNVLink is the link level of NVLink peers, tighter than P2PLinkSameBoard (6)
+const LinkNVLink int32 = 7
+
+// NVLinkInfo describes the NVLinks to a peer GPU
+type NVLinkInfo struct {
+	BusID     string `json:"BusID"`     // bus ID of the peer
+	Links     int32  `json:"Links"`     // number of links to the peer
+	Bandwidth int64  `json:"Bandwidth"` // total bandwidth to the peer in bytes/s per direction
+}
+
 type GpuInfo struct {
-	ID       string         `json:"UUID"`
-	Model    string         `json:"Model"`
-	Path     string         `json:"Path"`
-	Memory   MemoryInfo     `json:"Memory"`
-	PCI      PciInfo        `json:"PCI"`
-	Topology []TopologyInfo `json:"Topology"`
-	Found    bool           `json:"-"`
-	Index    int            `json:"-"`
-	InUse    bool           `json:"-"`
-	TopoDone bool           `json:"-"`
-	Name     string         `json:"-"`
+	ID          string         `json:"UUID"`
+	Model       string         `json:"Model"`
+	Path        string         `json:"Path"`
+	Power       int64          `json:"Power"` // W
+	CPUAffinity *int64         `json:"CPUAffinity"`
+	NUMANode    *int64         `json:"NUMANode,omitempty"`
+	CPUSet      string         `json:"CPUSet,omitempty"` // local CPUs in cpuset list format, e.g. 0-13,28-41
+	Clocks      ClockInfo      `json:"Clocks"`
+	Family      string         `json:"Family"`
+	Arch        string         `json:"Arch"`  // compute capability, e.g. 7.0
+	Cores       int64          `json:"Cores"` // CUDA cores
+	Memory      MemoryInfo     `json:"Memory"`
+	PCI         PciInfo        `json:"PCI"`
+	Topology    []TopologyInfo `json:"Topology"`
+	NVLinks     []NVLinkInfo   `json:"NVLinks,omitempty"`
+	// NVLinks to NVSwitches, NVML reports the switch as the remote device so peers reached through it are not in NVLinks
+	NVSwitchLinks int32 `json:"NVSwitchLinks,omitempty"`
+	// MIG partitioning of the GPU, not discovered, the GPU is allocated as a whole
+	MIG      *MIGInfo `json:"MIG,omitempty"`
+	Found    bool     `json:"-"`
+	Index    int      `json:"-"`
+	InUse    bool     `json:"-"`
+	TopoDone bool     `json:"-"`
+	Name     string   `json:"-"`
+	// health state, maintained by the health watcher
+	Unhealthy      bool      `json:"-"`
+	HealthReason   string    `json:"-"`
+	UnhealthySince time.Time `json:"-"`
+	// time at which a previously discovered GPU stopped being found
+	LostSince time.Time `json:"-"`
+}
+
+// MIGInfo describes the MIG instances a GPU is partitioned into
+type MIGInfo struct {
+	Profile   string `json:"Profile"`   // instance profile, e.g. 1g.5gb
+	Instances int    `json:"Instances"` // number of instances
+	Memory    int64  `json:"Memory"`    // per instance, in the units of Memory.Global
+	Cores     int64  `json:"Cores"`     // CUDA cores per instance
 }
 
 type VersionInfo struct {
@@ -42,8 +89,12 @@ type GpusInfo struct {
 	Gpus    []GpuInfo   `json:"Devices"`
 }
 
-func GetDevices() (*GpusInfo, error) {
-	output, err := exec.Command("/usr/local/bin/nvmlinfo", "json").Output()
+// DefaultSysfsRoot is where sysfs is mounted on the host
+const DefaultSysfsRoot = "/sys"
+
+// GetDevices runs NVML discovery, the NUMA locality of the devices is read from sysfs below sysfsRoot
+func GetDevices(sysfsRoot string) (*GpusInfo, error) {
+	output, err := exec.Command("/usr/local/bin/nvmlinfo", "json", "-sysfs", sysfsRoot).Output()
 	if err != nil {
 		return nil, err
 	}
@@ -56,3 +107,40 @@ func GetDevices() (*GpusInfo, error) {
 	//fmt.Printf("Get devs: %+v\n", gpus)
 	return gpus, nil
 }
+
+// NormalizeBusID returns the bus ID in lower case with a 4 digit domain, the form used by sysfs
+// NVML reports an 8 digit domain, e.g. 00000000:04:00.0, and nvidia-docker a 4 digit one
+func NormalizeBusID(busID string) string {
+	busID = strings.ToLower(busID)
+	parts := strings.SplitN(busID, ":", 2)
+	if len(parts) == 2 {
+		domain, err := strconv.ParseUint(parts[0], 16, 32)
+		if err == nil {
+			busID = fmt.Sprintf("%04x:%s", domain, parts[1])
+		}
+	}
+	return busID
+}
+
+// AllocationManifest describes the GPUs allocated to a container, it is made available inside the container
+type AllocationManifest struct {
+	Pod       string         `json:"Pod"`
+	Container string         `json:"Container"`
+	Gpus      []ManifestGpu  `json:"Gpus"`
+	Links     []ManifestLink `json:"Links"`
+}
+
+type ManifestGpu struct {
+	UUID   string `json:"UUID"`
+	BusID  string `json:"BusID"`
+	Model  string `json:"Model"`
+	Memory int64  `json:"Memory"`
+	Group  string `json:"Group"` // topology group path, e.g. gpugrp1/<id>/gpugrp0/<id>
+}
+
+// ManifestLink is the link level between two allocated GPUs, see TopologyInfo
+type ManifestLink struct {
+	From string `json:"From"`
+	To   string `json:"To"`
+	Link int32  `json:"Link"`
+}
@@ -0,0 +1,143 @@
+package nvidia
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/types"
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+)
+
+// matches the cards of a GPU in any group, the number of group levels is validated with the grouping policy
+var allocateFromRE = regexp.MustCompile("^" + types.DeviceGroupPrefix + "/.*/gpu/" + `([^/]+)/cards$`)
+
+// gpuIDsFromAllocateFrom returns the UUIDs of the GPUs the container is allocated from, sorted by requested resource
+func gpuIDsFromAllocateFrom(podKey string, container *types.ContainerInfo) []string {
+	ids := []string{}
+	for _, key := range utils.SortedStringKeys(container.AllocateFrom) {
+		res := container.AllocateFrom[types.ResourceName(key)]
+		utils.Logf(4, "PodName: %v -- searching for device UID: %v", podKey, res)
+		matches := allocateFromRE.FindStringSubmatch(string(res))
+		if len(matches) >= 2 {
+			ids = append(ids, matches[1])
+		}
+	}
+	return ids
+}
+
+// containerKey keys a container whose name is not known by the GPUs it is allocated
+// containers sharing GPUs, e.g. an init container and a running container, share the key
+func containerKey(ids []string) string {
+	sorted := append([]string{}, ids...)
+	sort.Strings(sorted)
+	return "gpus:" + strings.Join(sorted, ",")
+}
+
+// validateAssignment checks that the GPUs can be given to the pod, must be called with lock held
+// GPUs may be shared between containers of the same pod, e.g. an init container and a running container
+func (ngm *NvidiaGPUManager) validateAssignment(podName string, ids []string) error {
+	for _, id := range ids {
+		gpu, available := ngm.gpus[id]
+		if !available {
+			return fmt.Errorf("pod %v is allocated unknown GPU %v", podName, id)
+		}
+		if !gpu.Found {
+			return fmt.Errorf("pod %v is allocated GPU %v which is no longer found", podName, id)
+		}
+		if gpu.Unhealthy {
+			return fmt.Errorf("pod %v is allocated unhealthy GPU %v (%v)", podName, id, gpu.HealthReason)
+		}
+		if owner, assigned := ngm.gpuOwner[id]; assigned && owner != podName {
+			return fmt.Errorf("pod %v is allocated GPU %v which is already assigned to pod %v", podName, id, owner)
+		}
+	}
+	return nil
+}
+
+// assign records the GPUs as used by the container, must be called with lock held
+func (ngm *NvidiaGPUManager) assign(podName string, contName string, ids []string) {
+	if ngm.assignments == nil {
+		ngm.assignments = make(map[string]map[string][]string)
+		ngm.gpuOwner = make(map[string]string)
+	}
+	if ngm.assignments[podName] == nil {
+		ngm.assignments[podName] = make(map[string][]string)
+	}
+	ngm.assignments[podName][contName] = ids
+	for _, id := range ids {
+		ngm.gpuOwner[id] = podName
+		gpu := ngm.gpus[id]
+		gpu.InUse = true
+		ngm.gpus[id] = gpu
+	}
+}
+
+// releaseGPUs frees GPUs no longer used by any container of the pod, must be called with lock held
+func (ngm *NvidiaGPUManager) releaseGPUs(podName string, ids []string) {
+	stillUsed := make(map[string]bool)
+	for _, contIDs := range ngm.assignments[podName] {
+		for _, id := range contIDs {
+			stillUsed[id] = true
+		}
+	}
+	for _, id := range ids {
+		if stillUsed[id] || ngm.gpuOwner[id] != podName {
+			continue
+		}
+		delete(ngm.gpuOwner, id)
+		if gpu, available := ngm.gpus[id]; available {
+			gpu.InUse = false
+			ngm.gpus[id] = gpu
+		}
+	}
+}
+
+// ReleaseContainer frees the GPUs assigned to a container
+func (ngm *NvidiaGPUManager) ReleaseContainer(podName string, contName string) {
+	ngm.Lock()
+	defer ngm.Unlock()
+	ids := ngm.assignments[podName][contName]
+	delete(ngm.assignments[podName], contName)
+	ngm.removeContainerManifests(podName, contName)
+	if len(ngm.assignments[podName]) == 0 {
+		delete(ngm.assignments, podName)
+		ngm.removeManifests(podName)
+	}
+	ngm.releaseGPUs(podName, ids)
+	ngm.saveCheckpoint()
+}
+
+// ReleasePod frees all GPUs assigned to containers of a pod
+func (ngm *NvidiaGPUManager) ReleasePod(podName string) {
+	ngm.Lock()
+	defer ngm.Unlock()
+	ngm.releasePod(podName)
+}
+
+// releasePod frees all GPUs assigned to containers of a pod, must be called with lock held
+func (ngm *NvidiaGPUManager) releasePod(podName string) {
+	ids := []string{}
+	for _, contIDs := range ngm.assignments[podName] {
+		ids = append(ids, contIDs...)
+	}
+	delete(ngm.assignments, podName)
+	ngm.releaseGPUs(podName, ids)
+	ngm.removeManifests(podName)
+	ngm.saveCheckpoint()
+}
+
+// Assignments returns a copy of the pod -> container -> GPU UUIDs assignment table
+func (ngm *NvidiaGPUManager) Assignments() map[string]map[string][]string {
+	ngm.Lock()
+	defer ngm.Unlock()
+	copy := make(map[string]map[string][]string)
+	for podName, conts := range ngm.assignments {
+		copy[podName] = make(map[string][]string)
+		for contName, ids := range conts {
+			copy[podName][contName] = append([]string{}, ids...)
+		}
+	}
+	return copy
+}
@@ -0,0 +1,85 @@
+package nvidia
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+// per GPU attributes which can be advertised as group resources gpu/<id>/<attribute>
+// NVML reports neither CUDA cores nor memory bandwidth, on the NVML path they are known for the models in the
+// specifications table of the nvml package only, and not advertised for other models
+const (
+	AttributePower             = "power"              // power limit in W
+	AttributeClocksCores       = "clocks-cores"       // max SM clock in MHz
+	AttributeClocksMemory      = "clocks-memory"      // max memory clock in MHz
+	AttributeCores             = "cores"              // number of CUDA cores
+	AttributeMemoryBandwidth   = "memory-bandwidth"   // in bytes/s
+	AttributeComputeCapability = "compute-capability" // major*10 + minor, e.g. 70 for Volta
+)
+
+var attributes = []string{AttributePower, AttributeClocksCores, AttributeClocksMemory, AttributeCores, AttributeMemoryBandwidth,
+	AttributeComputeCapability}
+
+// ParseAttributes parses a comma separated list of attributes to advertise, such as "power,compute-capability"
+func ParseAttributes(list string) ([]string, error) {
+	attrs := []string{}
+	for _, attr := range strings.Split(list, ",") {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+		if !stringsContain(attributes, attr) {
+			return nil, fmt.Errorf("unknown attribute %v, known attributes are %v", attr, strings.Join(attributes, ","))
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+func stringsContain(list []string, val string) bool {
+	for _, elem := range list {
+		if elem == val {
+			return true
+		}
+	}
+	return false
+}
+
+// computeCapability encodes an architecture string such as "7.0" as 70, returns 0 if unknown
+func computeCapability(arch string) int64 {
+	parts := strings.SplitN(arch, ".", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+	major, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+	minor, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || minor > 9 {
+		return 0
+	}
+	return major*10 + minor
+}
+
+// gpuAttributes returns the advertised attributes of a GPU, attributes which are not known are left out
+func (ngm *NvidiaGPUManager) gpuAttributes(gpu nvgputypes.GpuInfo) map[string]int64 {
+	all := map[string]int64{
+		AttributePower:             gpu.Power,
+		AttributeClocksCores:       gpu.Clocks.Cores,
+		AttributeClocksMemory:      gpu.Clocks.Memory,
+		AttributeCores:             gpu.Cores,
+		AttributeMemoryBandwidth:   gpu.Memory.Bandwidth,
+		AttributeComputeCapability: computeCapability(gpu.Arch),
+	}
+	attrs := make(map[string]int64)
+	for _, attr := range ngm.AdvertisedAttributes {
+		if val := all[attr]; val != 0 {
+			attrs[attr] = val
+		}
+	}
+	return attrs
+}
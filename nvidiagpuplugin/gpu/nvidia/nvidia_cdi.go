@@ -0,0 +1,167 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	devtypes "github.com/Microsoft/KubeDevice-API/pkg/device"
+)
+
+const (
+	// AllocationModeNvidiaRuntime passes NVIDIA_VISIBLE_DEVICES for use with the nvidia container runtime hook
+	AllocationModeNvidiaRuntime = "nvidia-runtime"
+	// AllocationModeCDI writes a Container Device Interface spec and returns CDI device names
+	AllocationModeCDI = "cdi"
+	// AllocationModeMounts returns device nodes and driver mounts directly, for runtimes without the nvidia hook
+	// the driver files are mounted in <DriverContainerPath>/lib64 and bin, LD_LIBRARY_PATH and PATH are left to the
+	// image, CUDA images include /usr/local/nvidia/lib64 and /usr/local/nvidia/bin, the default DriverContainerPath
+	AllocationModeMounts = "mounts"
+
+	cdiVersion         = "0.5.0"
+	cdiKind            = "kubegpu.microsoft.com/gpu" // not nvidia.com/gpu of the nvidia-ctk specs, both can be installed
+	cdiNICKind         = "kubegpu.microsoft.com/rdma"
+	defaultCDISpecDir  = "/etc/cdi"
+	cdiSpecFileName    = "kubegpu-nvidia.json"
+	cdiNICSpecFileName = "kubegpu-rdma.json"
+)
+
+// common device nodes needed by all containers using GPUs
+var nvidiaControlDevices = []string{"/dev/nvidiactl", "/dev/nvidia-uvm", "/dev/nvidia-uvm-tools"}
+
+// CDI spec, see https://github.com/cncf-tags/container-device-interface/blob/main/SPEC.md
+type cdiSpec struct {
+	Version        string            `json:"cdiVersion"`
+	Kind           string            `json:"kind"`
+	Devices        []cdiDevice       `json:"devices"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits,omitempty"`
+}
+
+type cdiDevice struct {
+	Name           string            `json:"name"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+type cdiContainerEdits struct {
+	Env         []string        `json:"env,omitempty"`
+	DeviceNodes []cdiDeviceNode `json:"deviceNodes,omitempty"`
+	Mounts      []cdiMount      `json:"mounts,omitempty"`
+}
+
+type cdiDeviceNode struct {
+	Path string `json:"path"`
+}
+
+type cdiMount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath"`
+	Options       []string `json:"options,omitempty"`
+}
+
+func cdiDeviceName(kind string, id string) string {
+	return kind + "=" + id
+}
+
+func cdiDeviceNames(kind string, ids []string) []string {
+	names := []string{}
+	for _, id := range ids {
+		names = append(names, cdiDeviceName(kind, id))
+	}
+	return names
+}
+
+func mountsToCDI(mounts []devtypes.Mount) []cdiMount {
+	cdiMounts := []cdiMount{}
+	for _, mount := range mounts {
+		options := []string{"bind", "nosuid", "nodev"}
+		if mount.Readonly {
+			options = append(options, "ro")
+		}
+		cdiMounts = append(cdiMounts, cdiMount{HostPath: mount.HostPath, ContainerPath: mount.ContainerPath, Options: options})
+	}
+	return cdiMounts
+}
+
+// driverMounts returns the mounts which make the driver available inside containers, must be called with lock held
+// either the DriverHostPath directory if set, or the driver files discovered on the host
+func (ngm *NvidiaGPUManager) driverMounts() []devtypes.Mount {
+	if ngm.DriverHostPath == "" {
+		return ngm.discoveredDriverMounts()
+	}
+	return []devtypes.Mount{{
+		HostPath:      ngm.DriverHostPath,
+		ContainerPath: ngm.DriverContainerPath,
+		Readonly:      true,
+	}}
+}
+
+// cdiSpec describes the found GPUs, must be called with lock held
+func (ngm *NvidiaGPUManager) cdiSpec() *cdiSpec {
+	spec := &cdiSpec{Version: cdiVersion, Kind: cdiKind}
+	for _, id := range ngm.indexToID {
+		spec.Devices = append(spec.Devices, cdiDevice{
+			Name: id,
+			ContainerEdits: cdiContainerEdits{
+				DeviceNodes: []cdiDeviceNode{{Path: ngm.gpus[id].Path}},
+			},
+		})
+	}
+	for _, path := range nvidiaControlDevices {
+		spec.ContainerEdits.DeviceNodes = append(spec.ContainerEdits.DeviceNodes, cdiDeviceNode{Path: path})
+	}
+	// keep the nvidia runtime hook, if installed, from injecting devices itself
+	spec.ContainerEdits.Env = []string{"NVIDIA_VISIBLE_DEVICES=void"}
+	spec.ContainerEdits.Mounts = mountsToCDI(ngm.driverMounts())
+	return spec
+}
+
+// cdiNICSpec describes the discovered RDMA NICs, nil if there are none, must be called with lock held
+func (ngm *NvidiaGPUManager) cdiNICSpec() *cdiSpec {
+	if len(ngm.nics) == 0 {
+		return nil
+	}
+	spec := &cdiSpec{Version: cdiVersion, Kind: cdiNICKind}
+	for _, name := range sortedNICNames(ngm.nics) {
+		device := cdiDevice{Name: name}
+		for _, path := range ngm.nics[name].Devices {
+			device.ContainerEdits.DeviceNodes = append(device.ContainerEdits.DeviceNodes, cdiDeviceNode{Path: path})
+		}
+		spec.Devices = append(spec.Devices, device)
+	}
+	for _, path := range rdmaControlDevices {
+		spec.ContainerEdits.DeviceNodes = append(spec.ContainerEdits.DeviceNodes, cdiDeviceNode{Path: path})
+	}
+	return spec
+}
+
+// writeCDISpec writes the CDI specs for the found GPUs and NICs to CDISpecDir, must be called with lock held
+// the NIC spec is removed if there are no NICs
+func (ngm *NvidiaGPUManager) writeCDISpec() error {
+	if err := os.MkdirAll(ngm.CDISpecDir, 0755); err != nil {
+		return err
+	}
+	if err := writeCDISpecFile(filepath.Join(ngm.CDISpecDir, cdiSpecFileName), ngm.cdiSpec()); err != nil {
+		return err
+	}
+	nicPath := filepath.Join(ngm.CDISpecDir, cdiNICSpecFileName)
+	if nicSpec := ngm.cdiNICSpec(); nicSpec != nil {
+		return writeCDISpecFile(nicPath, nicSpec)
+	}
+	if err := os.Remove(nicPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func writeCDISpecFile(path string, spec *cdiSpec) error {
+	body, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
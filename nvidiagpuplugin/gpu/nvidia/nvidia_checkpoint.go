@@ -0,0 +1,126 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+)
+
+type checkpointData struct {
+	Assignments map[string]map[string][]string `json:"Assignments"` // pod -> container -> GPU UUIDs
+}
+
+type checkpointFile struct {
+	Data     json.RawMessage `json:"Data"`
+	Checksum uint32          `json:"Checksum"` // CRC32 of Data
+}
+
+// writeCheckpoint saves the assignment table to CheckpointPath, must be called with lock held
+func (ngm *NvidiaGPUManager) writeCheckpoint() error {
+	if ngm.CheckpointPath == "" {
+		return nil
+	}
+	data, err := json.Marshal(checkpointData{Assignments: ngm.assignments})
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(checkpointFile{Data: data, Checksum: crc32.ChecksumIEEE(data)})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ngm.CheckpointPath), 0755); err != nil {
+		return err
+	}
+	// write to temporary file and rename so that a crash never leaves a partial checkpoint
+	tmpPath := ngm.CheckpointPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, ngm.CheckpointPath)
+}
+
+func (ngm *NvidiaGPUManager) saveCheckpoint() {
+	if err := ngm.writeCheckpoint(); err != nil {
+		utils.Errorf("Writing checkpoint %v fails: %v", ngm.CheckpointPath, err)
+	}
+}
+
+func readCheckpoint(path string) (*checkpointData, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file checkpointFile
+	if err := json.Unmarshal(body, &file); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(file.Data) != file.Checksum {
+		return nil, fmt.Errorf("checkpoint %v is corrupted, checksum mismatch", path)
+	}
+	var data checkpointData
+	if err := json.Unmarshal(file.Data, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// loadCheckpoint restores assignments from CheckpointPath, dropping GPUs which are not found anymore
+func (ngm *NvidiaGPUManager) loadCheckpoint() error {
+	ngm.Lock()
+	defer ngm.Unlock()
+	if ngm.CheckpointPath == "" {
+		return nil
+	}
+	data, err := readCheckpoint(ngm.CheckpointPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for podName, conts := range data.Assignments {
+		for contName, ids := range conts {
+			found := []string{}
+			for _, id := range ids {
+				if ngm.gpus[id].Found {
+					found = append(found, id)
+				} else {
+					utils.Logf(1, "Dropping checkpointed GPU %v of pod %v container %v, GPU not found", id, podName, contName)
+				}
+			}
+			if len(found) > 0 {
+				ngm.assign(podName, contName, found)
+			}
+		}
+	}
+	ngm.saveCheckpoint()
+	return nil
+}
+
+// GarbageCollect releases GPUs of pods which are no longer alive, as reported by LivePods, including pods restored
+// from the checkpoint which have ended while the manager was not running
+func (ngm *NvidiaGPUManager) GarbageCollect() error {
+	if ngm.LivePods == nil {
+		return fmt.Errorf("no live pods source, assignments cannot be garbage collected")
+	}
+	pods, err := ngm.LivePods()
+	if err != nil {
+		return err
+	}
+	live := liveKeys(pods)
+	ngm.Lock()
+	podNames := utils.SortedStringKeys(ngm.assignments)
+	ngm.Unlock()
+	for _, podName := range podNames {
+		if !live[podName] {
+			utils.Logf(1, "Releasing GPUs of pod %v which is no longer alive", podName)
+			ngm.ReleasePod(podName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+
+	devtypes "github.com/Microsoft/KubeDevice-API/pkg/device"
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+)
+
+type driverLibrary struct {
+	name   string // e.g. libcuda.so, the file on the host is name.<driver version>
+	soname string // name the library is loaded by, empty if same as file name
+}
+
+// driver userspace needed by CUDA applications, see libnvidia-container for the full list
+var driverLibraries = []driverLibrary{
+	{"libcuda.so", "libcuda.so.1"},
+	{"libnvidia-ml.so", "libnvidia-ml.so.1"},
+	{"libnvidia-ptxjitcompiler.so", "libnvidia-ptxjitcompiler.so.1"},
+	{"libnvidia-fatbinaryloader.so", ""},
+	{"libnvidia-compiler.so", ""},
+	{"libnvidia-opencl.so", "libnvidia-opencl.so.1"},
+	{"libnvidia-cfg.so", "libnvidia-cfg.so.1"},
+	{"libnvidia-encode.so", "libnvidia-encode.so.1"},
+	{"libnvcuvid.so", "libnvcuvid.so.1"},
+}
+
+var driverBinaries = []string{
+	"nvidia-smi",
+	"nvidia-debugdump",
+	"nvidia-persistenced",
+	"nvidia-cuda-mps-control",
+	"nvidia-cuda-mps-server",
+}
+
+var driverLibraryDirs = []string{
+	"/usr/lib/x86_64-linux-gnu",
+	"/usr/lib/aarch64-linux-gnu",
+	"/usr/lib64",
+	"/usr/lib",
+	"/usr/local/nvidia/lib64",
+}
+
+var driverBinaryDirs = []string{
+	"/usr/bin",
+	"/usr/local/bin",
+	"/usr/local/nvidia/bin",
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// findFile returns the host path (without DriverRoot) of the first dir containing name
+func findFile(root string, dirs []string, name string) string {
+	for _, dir := range dirs {
+		if fileExists(filepath.Join(root, dir, name)) {
+			return filepath.Join(dir, name)
+		}
+	}
+	return ""
+}
+
+// discoverDriverFiles finds the driver libraries matching version and driver binaries below root
+// returns mounts placing them in containerPath/lib64 and containerPath/bin, the layout used by nvidia-docker
+func discoverDriverFiles(root string, version string, containerPath string) []devtypes.Mount {
+	mounts := []devtypes.Mount{}
+	if version == "" {
+		utils.Logf(2, "Driver version unknown, not discovering driver libraries")
+	} else {
+		for _, lib := range driverLibraries {
+			fileName := lib.name + "." + version
+			hostPath := findFile(root, driverLibraryDirs, fileName)
+			if hostPath == "" {
+				utils.Logf(4, "Driver library %v not found", fileName)
+				continue
+			}
+			mounts = append(mounts, devtypes.Mount{
+				HostPath:      hostPath,
+				ContainerPath: filepath.Join(containerPath, "lib64", fileName),
+				Readonly:      true,
+			})
+			if lib.soname != "" {
+				mounts = append(mounts, devtypes.Mount{
+					HostPath:      hostPath,
+					ContainerPath: filepath.Join(containerPath, "lib64", lib.soname),
+					Readonly:      true,
+				})
+			}
+		}
+	}
+	for _, bin := range driverBinaries {
+		hostPath := findFile(root, driverBinaryDirs, bin)
+		if hostPath == "" {
+			utils.Logf(4, "Driver binary %v not found", bin)
+			continue
+		}
+		mounts = append(mounts, devtypes.Mount{
+			HostPath:      hostPath,
+			ContainerPath: filepath.Join(containerPath, "bin", bin),
+			Readonly:      true,
+		})
+	}
+	return mounts
+}
+
+// discoveredDriverMounts returns the driver files for the current driver version, must be called with lock held
+func (ngm *NvidiaGPUManager) discoveredDriverMounts() []devtypes.Mount {
+	if ngm.driverFiles == nil || ngm.driverFilesVersion != ngm.version.Driver {
+		ngm.driverFiles = discoverDriverFiles(ngm.DriverRoot, ngm.version.Driver, ngm.DriverContainerPath)
+		ngm.driverFilesVersion = ngm.version.Driver
+		utils.Logf(3, "Discovered driver files for version %v: %+v", ngm.version.Driver, ngm.driverFiles)
+	}
+	return ngm.driverFiles
+}
+
+// deviceNodes returns the device nodes for the given GPUs and the control devices, must be called with lock held
+func (ngm *NvidiaGPUManager) deviceNodes(ids []string) []string {
+	devices := []string{}
+	for _, id := range ids {
+		devices = append(devices, ngm.gpus[id].Path)
+	}
+	return append(devices, nvidiaControlDevices...)
+}
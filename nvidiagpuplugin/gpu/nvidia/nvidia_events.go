@@ -0,0 +1,145 @@
+package nvidia
+
+import (
+	"time"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+const defaultRefreshInterval = 5 * time.Minute
+
+type InventoryEventType int
+
+const (
+	GPUAdded InventoryEventType = iota
+	GPURemoved
+	GPUHealthChanged
+	DriverVersionChanged
+	TopologyChanged
+)
+
+func (t InventoryEventType) String() string {
+	switch t {
+	case GPUAdded:
+		return "GPUAdded"
+	case GPURemoved:
+		return "GPURemoved"
+	case GPUHealthChanged:
+		return "GPUHealthChanged"
+	case DriverVersionChanged:
+		return "DriverVersionChanged"
+	case TopologyChanged:
+		return "TopologyChanged"
+	}
+	return "Unknown"
+}
+
+// InventoryEvent describes a change in the GPUs managed by NvidiaGPUManager
+type InventoryEvent struct {
+	Type InventoryEventType
+	ID   string             // UUID of the GPU, empty for node level events such as DriverVersionChanged
+	GPU  nvgputypes.GpuInfo // state of the GPU after the change
+	Old  string             // previous driver version or topology name
+	New  string             // new driver version or topology name
+}
+
+// Subscribe returns a channel on which inventory events are delivered, and a function to cancel the subscription
+// Events are dropped for subscribers whose channel is full
+func (ngm *NvidiaGPUManager) Subscribe(bufferSize int) (<-chan InventoryEvent, func()) {
+	ngm.Lock()
+	defer ngm.Unlock()
+	if ngm.subscribers == nil {
+		ngm.subscribers = make(map[int]chan InventoryEvent)
+	}
+	id := ngm.nextSubscriberID
+	ngm.nextSubscriberID++
+	ch := make(chan InventoryEvent, bufferSize)
+	ngm.subscribers[id] = ch
+	cancel := func() {
+		ngm.Lock()
+		defer ngm.Unlock()
+		if _, available := ngm.subscribers[id]; available {
+			delete(ngm.subscribers, id)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// publish sends event to all subscribers, must be called with lock held
+func (ngm *NvidiaGPUManager) publish(event InventoryEvent) {
+	utils.Logf(4, "Inventory event %v for %v", event.Type, event.ID)
+	for id, ch := range ngm.subscribers {
+		select {
+		case ch <- event:
+		default:
+			utils.Logf(2, "Subscriber %d is not keeping up, dropping event %v for %v", id, event.Type, event.ID)
+		}
+	}
+}
+
+// publishInventoryChanges compares the GPUs before and after discovery, must be called with lock held
+func (ngm *NvidiaGPUManager) publishInventoryChanges(before map[string]nvgputypes.GpuInfo, oldVersion nvgputypes.VersionInfo, newVersion nvgputypes.VersionInfo) {
+	if len(ngm.subscribers) == 0 {
+		return
+	}
+	if oldVersion.Driver != newVersion.Driver && oldVersion.Driver != "" {
+		ngm.publish(InventoryEvent{Type: DriverVersionChanged, Old: oldVersion.Driver, New: newVersion.Driver})
+	}
+	for _, id := range ngm.indexToID {
+		gpu := ngm.gpus[id]
+		prev, available := before[id]
+		if !available || !prev.Found {
+			ngm.publish(InventoryEvent{Type: GPUAdded, ID: id, GPU: gpu, New: gpu.Name})
+		} else if prev.Name != gpu.Name {
+			ngm.publish(InventoryEvent{Type: TopologyChanged, ID: id, GPU: gpu, Old: prev.Name, New: gpu.Name})
+		}
+	}
+	for id, prev := range before {
+		if prev.Found && !ngm.gpus[id].Found {
+			ngm.publish(InventoryEvent{Type: GPURemoved, ID: id, GPU: ngm.gpus[id], Old: prev.Name})
+		}
+	}
+}
+
+// StartRefresh periodically rediscovers GPUs so that subscribers see changes without calls to UpdateNodeInfo
+func (ngm *NvidiaGPUManager) StartRefresh() {
+	ngm.Lock()
+	defer ngm.Unlock()
+	if ngm.refreshStop != nil || ngm.RefreshInterval <= 0 {
+		return
+	}
+	ngm.refreshStop = make(chan struct{})
+	go func(interval time.Duration, stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := ngm.updateGPUInfo(true); err != nil {
+					utils.Errorf("Refreshing GPU info fails: %v", err)
+				}
+				if ngm.LivePods == nil {
+					continue
+				}
+				if err := ngm.GarbageCollect(); err != nil {
+					utils.Errorf("Garbage collecting assignments fails: %v", err)
+				}
+			}
+		}
+	}(ngm.RefreshInterval, ngm.refreshStop)
+}
+
+// Stop stops the background refresh and health watch
+func (ngm *NvidiaGPUManager) Stop() {
+	ngm.StopHealthWatch()
+	ngm.Lock()
+	defer ngm.Unlock()
+	if ngm.refreshStop != nil {
+		close(ngm.refreshStop)
+		ngm.refreshStop = nil
+	}
+}
@@ -33,9 +33,34 @@ func NewFakeNvidiaGPUManager(info *nvgputypes.GpusInfo, volume string, volumeDri
 		volume:       volume,
 		volumeDriver: volumeDriver,
 	}
-	return &NvidiaGPUManager{
+	ngm := &NvidiaGPUManager{
 		gpus:    make(map[string]nvgputypes.GpuInfo),
 		np:      plugin,
 		useNVML: false,
-	}, nil
+	}
+	ngm.setDefaults()
+	return ngm, nil
+}
+
+// no GPU has more than a TiB of memory, so memory above is in bytes
+const maxMemoryMiB = int64(1024) * int64(1024)
+
+// InNVMLUnits returns true if the GPUs are in the units reported by NVML, bytes and bytes/s, as printed by nvmlinfo json,
+// rather than in the MiB and MB units of the nvidia docker plugin the fake plugin reports
+func InNVMLUnits(info *nvgputypes.GpusInfo) bool {
+	for _, gpu := range info.Gpus {
+		if gpu.Memory.Global > maxMemoryMiB {
+			return true
+		}
+	}
+	return false
+}
+
+// ToPluginUnits converts the GPUs from the units reported by NVML to those of the nvidia docker plugin
+func ToPluginUnits(info *nvgputypes.GpusInfo) {
+	for i := range info.Gpus {
+		info.Gpus[i].Memory.Global /= int64(1024) * int64(1024)
+		info.Gpus[i].PCI.Bandwidth /= int64(1000) * int64(1000)
+		info.Gpus[i].Memory.Bandwidth /= int64(1000) * int64(1000)
+	}
 }
@@ -2,7 +2,9 @@ package nvidia
 
 import (
 	"encoding/json"
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -12,6 +14,7 @@ import (
 	"github.com/Microsoft/KubeDevice-API/pkg/utils"
 	gputypes "github.com/Microsoft/KubeGPU/gpuplugintypes"
 	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvml"
 
 	"strconv"
 )
@@ -28,17 +31,113 @@ type NvidiaGPUManager struct {
 	useNVML         bool
 	GpusInfo        *nvgputypes.GpusInfo
 	nvmlLastGetTime time.Time
+	// health watching
+	healthSource             nvgputypes.HealthEventSource
+	retiredPagesSource       nvgputypes.RetiredPagesSource
+	healthStop               chan struct{}
+	HealthRecoveryPeriod     time.Duration // time without critical events before an unhealthy device is used again
+	RetiredPagesThreshold    uint64        // number of retired pages at which a device is considered unhealthy
+	RetiredPagesPollInterval time.Duration // how often the retired pages of the devices are polled
+	// inventory change notification
+	version          nvgputypes.VersionInfo
+	subscribers      map[int]chan InventoryEvent
+	nextSubscriberID int
+	refreshStop      chan struct{}
+	RefreshInterval  time.Duration // how long discovered devices are cached, and how often they are refreshed in background, <=0 caches them forever
+	// allocation bookkeeping
+	assignments map[string]map[string][]string // pod key -> container -> GPU UUIDs
+	gpuOwner    map[string]string              // GPU UUID -> pod key
+	// checkpointing of assignments
+	CheckpointPath string                   // file assignments are saved to, empty disables checkpointing
+	LivePods       func() ([]PodRef, error) // returns the pods alive on the node, used to release GPUs of pods which have ended
+	// allocation
+	AllocationMode      string // AllocationModeNvidiaRuntime or AllocationModeCDI
+	CDISpecDir          string // directory CDI specs are written to
+	DriverHostPath      string // host directory with the driver userspace, mounted into containers if set
+	DriverContainerPath string // where DriverHostPath or the discovered driver files are mounted inside containers
+	DriverRoot          string // root of the host filesystem where driver files are discovered
+	driverFiles         []devtypes.Mount
+	driverFilesVersion  string
+	NCCLHints           bool // return NCCL environment hints and an NCCL topology file for the allocated GPUs
+	CPUAffinityHints    bool // return the suggested cpuset and NUMA node, or CPU socket, for the allocated GPUs
+	// allocation manifest
+	ManifestDir           string // host directory manifests are written to, empty disables them
+	NCCLTopoDir           string // host directory NCCL topology files are written to, ManifestDir if empty
+	ManifestContainerPath string // where the manifest is mounted inside the container
+	NCCLTopoContainerPath string // where the NCCL topology file is mounted inside the container
+	// topology grouping
+	GroupingPolicy     *GroupingPolicy
+	GroupingPolicyPath string            // file the grouping policy is loaded from in New, if set
+	fullyConnected     bool              // all GPUs are connected to the NVSwitches
+	groupNames         map[string]string // <level>/<GPU ID> -> name of its group, for groups named after a bus ID
+	// RDMA NICs placed in the GPU groups
+	DiscoverRDMANICs bool   // discover InfiniBand and RoCE NICs and advertise them next to the nearest GPUs
+	SysfsRoot        string // root of sysfs the locality of GPUs and NICs is discovered from, not read if empty
+	nics             map[string]nicInfo
+	// extended attributes, e.g. AttributePower, advertised as gpu/<id>/<attribute>, none by default
+	AdvertisedAttributes []string
 }
 
 // NewNvidiaGPUManager returns a GPUManager that manages local Nvidia GPUs.
 // TODO: Migrate to use pod level cgroups and make it generic to all runtimes.
 func NewNvidiaGPUManager() (devtypes.Device, error) {
-	ngm := &NvidiaGPUManager{useNVML: true}
+	ngm := &NvidiaGPUManager{useNVML: true, healthSource: nvml.NewEventSource(),
+		retiredPagesSource: nvml.NewRetiredPagesSource(), RefreshInterval: defaultRefreshInterval,
+		SysfsRoot: nvgputypes.DefaultSysfsRoot, LivePods: KubeletLivePods(defaultKubeletPodsURL)}
+	if fileExists(defaultGroupingPolicyPath) {
+		ngm.GroupingPolicyPath = defaultGroupingPolicyPath
+	}
 	return ngm, ngm.New()
 }
 
+// setDefaults fills in configuration which has not been set
+func (ngm *NvidiaGPUManager) setDefaults() {
+	if ngm.HealthRecoveryPeriod == 0 {
+		ngm.HealthRecoveryPeriod = defaultHealthRecoveryPeriod
+	}
+	if ngm.RetiredPagesThreshold == 0 {
+		ngm.RetiredPagesThreshold = DefaultRetiredPagesThreshold
+	}
+	if ngm.RetiredPagesPollInterval == 0 {
+		ngm.RetiredPagesPollInterval = defaultRetiredPagesPollInterval
+	}
+	if ngm.AllocationMode == "" {
+		ngm.AllocationMode = AllocationModeNvidiaRuntime
+	}
+	if ngm.CDISpecDir == "" {
+		ngm.CDISpecDir = defaultCDISpecDir
+	}
+	if ngm.DriverContainerPath == "" {
+		ngm.DriverContainerPath = "/usr/local/nvidia"
+	}
+	if ngm.DriverRoot == "" {
+		ngm.DriverRoot = "/"
+	}
+	if ngm.ManifestContainerPath == "" {
+		ngm.ManifestContainerPath = defaultManifestContainerPath
+	}
+	if ngm.NCCLTopoContainerPath == "" {
+		ngm.NCCLTopoContainerPath = defaultNCCLTopoContainerPath
+	}
+	if ngm.GroupingPolicy == nil {
+		ngm.GroupingPolicy = DefaultGroupingPolicy()
+	}
+}
+
 func (ngm *NvidiaGPUManager) New() error {
 	ngm.gpus = make(map[string]nvgputypes.GpuInfo)
+	if ngm.GroupingPolicyPath != "" {
+		policy, err := LoadGroupingPolicy(ngm.GroupingPolicyPath)
+		if err != nil {
+			return err
+		}
+		ngm.GroupingPolicy = policy
+	} else if ngm.GroupingPolicy != nil {
+		if err := ngm.GroupingPolicy.Validate(); err != nil {
+			return err
+		}
+	}
+	ngm.setDefaults()
 	if !ngm.useNVML {
 		plugin := &NvidiaDockerPlugin{}
 		ngm.np = plugin
@@ -59,39 +158,90 @@ func (ngm *NvidiaGPUManager) GetName() string {
 	return "nvidiagpu"
 }
 
+// groupIDFromBusID converts a PCI bus ID such as "00000000:04:00.0" to a group ID usable in resource names
+// the PCI domain is normalized to four digits since NVML and nvidia-docker report different widths
+func groupIDFromBusID(busID string) string {
+	return strings.NewReplacer(":", "_", ".", "_").Replace(nvgputypes.NormalizeBusID(busID))
+}
+
+// sortedByBusID returns the IDs of found GPUs ordered by PCI bus ID
+func (ngm *NvidiaGPUManager) sortedByBusID() []string {
+	ids := []string{}
+	for _, id := range ngm.indexToID {
+		if ngm.gpus[id].Found {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return groupIDFromBusID(ngm.gpus[ids[i]].PCI.BusID) < groupIDFromBusID(ngm.gpus[ids[j]].PCI.BusID)
+	})
+	return ids
+}
+
 // topology discovery
-func (ngm *NvidiaGPUManager) topologyDiscovery(links []int32, level int32) {
-	for id, copy := range ngm.gpus {
-		copy.TopoDone = false
-		ngm.gpus[id] = copy
+// the groups of each level are formed within the groups of the level above, starting at the top level, so that
+// they nest, and no group spans NUMA nodes
+// groups are named after the hardware they share, see groupIDs, so that names do not depend on the order in
+// which devices are enumerated, and do not change when a GPU disappears
+func (ngm *NvidiaGPUManager) topologyDiscovery(levels [][]int32) {
+	groups := [][]string{ngm.sortedByBusID()}
+	prefixes := make(map[string]string)
+	pciPaths := ngm.gpuPCIPaths()
+	for level := len(levels) - 1; level >= 0; level-- {
+		subgroups := [][]string{}
+		for _, group := range groups {
+			split := ngm.splitGroup(group, levels[level])
+			for i, groupID := range ngm.groupIDs(split, level, level == len(levels)-1, pciPaths) {
+				for _, id := range split[i] {
+					prefixes[id] += "gpugrp" + strconv.Itoa(level) + "/" + groupID + "/"
+				}
+			}
+			subgroups = append(subgroups, split...)
+		}
+		groups = subgroups
 	}
-	linkID := 0
-	for _, id := range ngm.indexToID {
-		copy := ngm.gpus[id]
-		if !ngm.gpus[id].Found || ngm.gpus[id].TopoDone {
+	for id, prefix := range prefixes {
+		gpu := ngm.gpus[id]
+		gpu.Name = prefix + gpu.Name
+		gpu.TopoDone = true
+		ngm.gpus[id] = gpu
+	}
+}
+
+// splitGroup splits GPUs ordered by PCI bus ID into groups, each GPU not yet in a group starts one with its peers
+// in ids which are linked to it by one of links and are on the same NUMA node, must be called with lock held
+func (ngm *NvidiaGPUManager) splitGroup(ids []string, links []int32) [][]string {
+	members := make(map[string]bool)
+	for _, id := range ids {
+		members[id] = true
+	}
+	inGroup := make(map[string]bool)
+	groups := [][]string{}
+	for _, id := range ids {
+		if inGroup[id] {
 			continue
 		}
-		prefix := "gpugrp" + strconv.Itoa(int(level)) + "/" + strconv.Itoa(int(linkID))
-		linkID++
-		copy.Name = prefix + "/" + ngm.gpus[id].Name
-		copy.TopoDone = true
-		ngm.gpus[id] = copy
-		for _, topolink := range ngm.gpus[id].Topology {
-			if arrayContains(links, topolink.Link) {
-				idOnLink := ngm.busIDToID[topolink.BusID]
-				gpuOnLink := ngm.gpus[idOnLink]
-				if gpuOnLink.Found {
-					gpuOnLink.Name = prefix + "/" + gpuOnLink.Name
-					gpuOnLink.TopoDone = true
-					ngm.gpus[idOnLink] = gpuOnLink
-				}
+		group := []string{id}
+		inGroup[id] = true
+		for _, topolink := range ngm.links(id) {
+			idOnLink := ngm.busIDToID[topolink.BusID]
+			if members[idOnLink] && !inGroup[idOnLink] && arrayContains(links, topolink.Link) && !ngm.differentNUMANodes(id, idOnLink) {
+				group = append(group, idOnLink)
+				inGroup[idOnLink] = true
 			}
 		}
+		groups = append(groups, group)
 	}
+	return groups
 }
 
 // Initialize the GPU devices
 func (ngm *NvidiaGPUManager) UpdateGPUInfo() error {
+	return ngm.updateGPUInfo(false)
+}
+
+// updateGPUInfo discovers GPUs, force skips the cached NVML results
+func (ngm *NvidiaGPUManager) updateGPUInfo(force bool) error {
 	ngm.Lock()
 	defer ngm.Unlock()
 
@@ -108,8 +258,8 @@ func (ngm *NvidiaGPUManager) UpdateGPUInfo() error {
 		}
 	} else {
 		timeElapsed := time.Now().Sub(ngm.nvmlLastGetTime)
-		if ngm.GpusInfo == nil || timeElapsed.Seconds() > 5*60.0 {
-			gpuPtr, err := nvgputypes.GetDevices()
+		if force || ngm.GpusInfo == nil || (ngm.RefreshInterval > 0 && timeElapsed >= ngm.RefreshInterval) {
+			gpuPtr, err := nvgputypes.GetDevices(ngm.SysfsRoot)
 			if err != nil {
 				return err
 			}
@@ -124,13 +274,20 @@ func (ngm *NvidiaGPUManager) UpdateGPUInfo() error {
 	// convert certain resources to correct units, such as memory and Bandwidth
 	if !ngm.useNVML {
 		for i := range gpus.Gpus {
-			gpus.Gpus[i].Memory.Global *= int64(1024) * int64(1024) // in units of MiB
-			gpus.Gpus[i].PCI.Bandwidth *= int64(1000) * int64(1000) // in units of MB
+			gpus.Gpus[i].Memory.Global *= int64(1024) * int64(1024)    // in units of MiB
+			gpus.Gpus[i].PCI.Bandwidth *= int64(1000) * int64(1000)    // in units of MB
+			gpus.Gpus[i].Memory.Bandwidth *= int64(1000) * int64(1000) // in units of MB/s
 		}
 	}
+	if !ngm.useNVML {
+		// the nvidia docker plugin only reports the CPU socket
+		fillNUMANodes(ngm.SysfsRoot, &gpus)
+	}
 
+	before := make(map[string]nvgputypes.GpuInfo)
 	for key := range ngm.gpus {
 		copy := ngm.gpus[key]
+		before[key] = copy
 		copy.Found = false
 		ngm.gpus[key] = copy
 	}
@@ -142,6 +299,9 @@ func (ngm *NvidiaGPUManager) UpdateGPUInfo() error {
 		gpu, available := ngm.gpus[gpuFound.ID]
 		if available {
 			gpuFound.InUse = gpu.InUse
+			gpuFound.Unhealthy = gpu.Unhealthy
+			gpuFound.HealthReason = gpu.HealthReason
+			gpuFound.UnhealthySince = gpu.UnhealthySince
 		}
 		gpuFound.Found = true
 		gpuFound.Index = index
@@ -153,6 +313,14 @@ func (ngm *NvidiaGPUManager) UpdateGPUInfo() error {
 	}
 	// set numGpus to number found -- not to len(ngm.gpus)
 	ngm.numGpus = len(gpus.Gpus) // if ngm.numGpus <> len(ngm.gpus), then some gpus have gone missing
+	now := time.Now()
+	for key, copy := range ngm.gpus {
+		if !copy.Found && copy.LostSince.IsZero() {
+			utils.Logf(0, "GPU %v (%v) is no longer found, in use %v", key, copy.PCI.BusID, copy.InUse)
+			copy.LostSince = now
+			ngm.gpus[key] = copy
+		}
+	}
 
 	// perform topology discovery to reassign name
 	// more information regarding various "link types" can be found in https://github.com/nvidia/nvidia-docker/blob/master/src/nvml/nvml.go
@@ -172,18 +340,48 @@ func (ngm *NvidiaGPUManager) UpdateGPUInfo() error {
 	// NVML_TOPOLOGY_HOSTBRIDGE = 30 (level 3)
 	// NVML_TOPOLOGY_CPU = 40 (level 2)
 	// NVML_TOPOLOGY_SYSTEM = 50 (level 1)
+	// NVLink peers are at level 7, see nvgputypes.LinkNVLink
 	//
 	// can have more levels if desired, but perhaps two levels are sufficient
-	// link "5" discovery - put 6, 5, 4 in first group
-	ngm.topologyDiscovery([]int32{6, 5, 4}, 0)
+	// by default, link "5" discovery - put 6, 5, 4 in first group
 	// link "5, 3"" discovery - put all in higher group
-	ngm.topologyDiscovery([]int32{6, 5, 4, 3, 2, 1}, 1)
+	// on NVSwitch systems all GPUs are equal, so advertise a single flat group
+	ngm.fullyConnected = ngm.isFullyConnected()
+	if ngm.fullyConnected {
+		for level := range ngm.GroupingPolicy.Levels {
+			ngm.flatTopologyDiscovery(int32(level))
+		}
+	} else {
+		ngm.topologyDiscovery(ngm.GroupingPolicy.Levels)
+	}
+	if ngm.DiscoverRDMANICs && ngm.SysfsRoot != "" {
+		ngm.discoverNICs()
+	}
+
+	ngm.publishInventoryChanges(before, ngm.version, gpus.Version)
+	ngm.version = gpus.Version
+
+	if ngm.AllocationMode == AllocationModeCDI {
+		if err := ngm.writeCDISpec(); err != nil {
+			utils.Errorf("Writing CDI spec to %v fails: %v", ngm.CDISpecDir, err)
+		}
+	}
 
 	return nil
 }
 
 func (ngm *NvidiaGPUManager) Start() error {
 	_ = ngm.UpdateGPUInfo() // ignore error in updating, gpus stay at zero
+	if err := ngm.loadCheckpoint(); err != nil {
+		utils.Errorf("Loading checkpoint %v fails: %v", ngm.CheckpointPath, err)
+	}
+	if ngm.LivePods == nil {
+		utils.Logf(0, "No live pods source, GPUs of pods which have ended are not released")
+	} else if err := ngm.GarbageCollect(); err != nil {
+		utils.Errorf("Garbage collecting assignments fails: %v", err)
+	}
+	ngm.StartHealthWatch()
+	ngm.StartRefresh()
 	return nil
 }
 
@@ -195,56 +393,190 @@ func (ngm *NvidiaGPUManager) UpdateNodeInfo(nodeInfo *types.NodeInfo) error {
 		ngm.numGpus = 0
 		return err
 	}
+	ngm.recoverDevices(time.Now())
+	ngm.Lock()
+	defer ngm.Unlock()
 	utils.Logf(4, "NumGPUs found = %d", ngm.numGpus)
-	numGpus := int64(len(ngm.gpus))
+	for _, cond := range ngm.conditions() {
+		utils.Errorf("GPU condition %v on %v: %v", cond.Type, cond.ID, cond.Message)
+	}
+	// lost GPUs are not counted
+	numGpus := int64(ngm.numGpus)
 	nodeInfo.Capacity[gputypes.ResourceGPU] = numGpus
 	nodeInfo.Allocatable[gputypes.ResourceGPU] = numGpus
 	nodeInfo.KubeCap[gputypes.ResourceGPU] = numGpus
 	nodeInfo.KubeAlloc[gputypes.ResourceGPU] = numGpus
+	// versions are attributes of the node, not allocatable
+	if cuda, err := gputypes.EncodeCUDAVersion(ngm.version.CUDA); err == nil {
+		nodeInfo.Capacity[gputypes.AttributeCUDAVersion] = cuda
+	}
+	if driver, err := gputypes.EncodeDriverVersion(ngm.version.Driver); err == nil {
+		nodeInfo.Capacity[gputypes.AttributeDriverVersion] = driver
+	}
 	for _, val := range ngm.gpus {
 		if val.Found { // if currently discovered
 			types.AddGroupResource(nodeInfo.Capacity, val.Name+"/memory", val.Memory.Global)
-			types.AddGroupResource(nodeInfo.Allocatable, val.Name+"/memory", val.Memory.Global)
 			types.AddGroupResource(nodeInfo.Capacity, val.Name+"/cards", int64(1))
+			attrs := ngm.gpuAttributes(val)
+			for attr, attrVal := range attrs {
+				types.AddGroupResource(nodeInfo.Capacity, val.Name+"/"+attr, attrVal)
+			}
+			if val.Unhealthy { // unhealthy devices remain in capacity, but cannot be allocated
+				utils.Logf(3, "GPU %v is unhealthy (%v), not allocatable", val.ID, val.HealthReason)
+				continue
+			}
+			types.AddGroupResource(nodeInfo.Allocatable, val.Name+"/memory", val.Memory.Global)
 			types.AddGroupResource(nodeInfo.Allocatable, val.Name+"/cards", int64(1))
+			for attr, attrVal := range attrs {
+				types.AddGroupResource(nodeInfo.Allocatable, val.Name+"/"+attr, attrVal)
+			}
 		}
 	}
+	for _, nic := range ngm.nics {
+		types.AddGroupResource(nodeInfo.Capacity, nic.Group+"/count", int64(1))
+		types.AddGroupResource(nodeInfo.Allocatable, nic.Group+"/count", int64(1))
+	}
 	return nil
 }
 
-// For use with nvidia runtime (nvidia docker2)
-func (ngm *NvidiaGPUManager) Allocate(pod *types.PodInfo, container *types.ContainerInfo) ([]devtypes.Mount, []string, map[string]string, error) {
-	gpuList := []string{}
+type GPUConditionType string
+
+const (
+	// GPULostInUse is set when a GPU that is assigned to a container is no longer found
+	GPULostInUse GPUConditionType = "LostInUse"
+)
+
+type GPUCondition struct {
+	Type    GPUConditionType
+	ID      string
+	Since   time.Time
+	Message string
+}
+
+// LostGPUs returns the GPUs which were discovered earlier but are no longer found
+func (ngm *NvidiaGPUManager) LostGPUs() []nvgputypes.GpuInfo {
+	ngm.Lock()
+	defer ngm.Unlock()
+	lost := []nvgputypes.GpuInfo{}
+	for _, key := range utils.SortedStringKeys(ngm.gpus) {
+		if !ngm.gpus[key].Found {
+			lost = append(lost, ngm.gpus[key])
+		}
+	}
+	return lost
+}
+
+// Conditions returns abnormal conditions of the managed GPUs
+func (ngm *NvidiaGPUManager) Conditions() []GPUCondition {
 	ngm.Lock()
 	defer ngm.Unlock()
+	return ngm.conditions()
+}
 
+func (ngm *NvidiaGPUManager) conditions() []GPUCondition {
+	conds := []GPUCondition{}
+	for _, key := range utils.SortedStringKeys(ngm.gpus) {
+		gpu := ngm.gpus[key]
+		if !gpu.Found && gpu.InUse {
+			conds = append(conds, GPUCondition{
+				Type:    GPULostInUse,
+				ID:      key,
+				Since:   gpu.LostSince,
+				Message: fmt.Sprintf("GPU %v (%v) is in use but has not been found since %v", key, gpu.PCI.BusID, gpu.LostSince),
+			})
+		}
+	}
+	return conds
+}
+
+// For use with nvidia runtime (nvidia docker2)
+// The Device interface carries neither the namespace of the pod nor the name of the container, the pod is resolved
+// to the key of a live pod by its name, see podKey, and the container is keyed by its GPUs, callers which know them
+// should use AllocateContainer
+func (ngm *NvidiaGPUManager) Allocate(pod *types.PodInfo, container *types.ContainerInfo) ([]devtypes.Mount, []string, map[string]string, error) {
+	return ngm.allocate(pod.Name, true, "", container)
+}
+
+// AllocateContainer allocates the container contName of the pod identified by podKey, e.g. the pod UID as in
+// PodRef.Key, GPUs are owned by podKey and released through ReleaseContainer and ReleasePod with the same keys
+func (ngm *NvidiaGPUManager) AllocateContainer(podKey string, contName string, container *types.ContainerInfo) ([]devtypes.Mount, []string, map[string]string, error) {
+	return ngm.allocate(podKey, false, contName, container)
+}
+
+// allocate allocates the container of the pod, resolve resolves the pod name to the key of a live pod
+// GPUs owned by pods which are no longer alive are released first
+func (ngm *NvidiaGPUManager) allocate(podKey string, resolve bool, contName string, container *types.ContainerInfo) ([]devtypes.Mount, []string, map[string]string, error) {
 	if container.AllocateFrom == nil || 0 == len(container.AllocateFrom) {
 		return nil, nil, nil, nil
 	}
+	pods := ngm.livePods()
 
-	re := regexp.MustCompile(types.DeviceGroupPrefix + "/gpugrp1/.*/gpugrp0/.*/gpu/" + `(.*?)/cards`)
+	ngm.Lock()
+	defer ngm.Unlock()
 
-	for _, res := range container.AllocateFrom {
-		utils.Logf(4, "PodName: %v -- searching for device UID: %v", pod.Name, res)
-		matches := re.FindStringSubmatch(string(res))
-		if len(matches) >= 2 {
-			id := matches[1]
-			gpuList = append(gpuList, id)
+	gpuList := gpuIDsFromAllocateFrom(podKey, container)
+	if resolve {
+		podKey = ngm.podKey(podKey, gpuList, pods)
+	}
+	ngm.releaseDeadOwners(podKey, gpuList, pods)
+	if err := ngm.validateAssignment(podKey, gpuList); err != nil {
+		return nil, nil, nil, err
+	}
+	nicList := nicsFromAllocateFrom(container)
+	for _, name := range nicList {
+		if _, ok := ngm.nics[name]; !ok {
+			return nil, nil, nil, fmt.Errorf("RDMA NIC %v not found", name)
 		}
 	}
+	if contName == "" {
+		contName = containerKey(gpuList)
+	}
+	gpuList = ngm.topologyOrder(gpuList)
+	mounts := []devtypes.Mount{}
+	if ngm.ManifestDir != "" {
+		mount, err := ngm.writeManifest(podKey, contName, gpuList)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		mounts = append(mounts, *mount)
+	}
+	env := make(map[string]string)
+	if ngm.NCCLHints && len(gpuList) > 0 {
+		mount, err := ngm.writeNCCLTopology(podKey, contName, gpuList, nicList)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		mounts = append(mounts, *mount)
+		env["NCCL_TOPO_FILE"] = mount.ContainerPath
+	}
+	ngm.assign(podKey, contName, gpuList)
+	ngm.saveCheckpoint()
 
-	env := map[string]string{
-		"NVIDIA_VISIBLE_DEVICES": strings.Join(gpuList, ","),
+	for key, val := range ngm.cpuHints(gpuList) {
+		env[key] = val
+	}
+	if ngm.NCCLHints && len(nicList) > 0 {
+		env["NCCL_IB_HCA"] = strings.Join(nicList, ",")
+	}
+	if ngm.AllocationMode == AllocationModeCDI {
+		return mounts, append(cdiDeviceNames(cdiKind, gpuList), cdiDeviceNames(cdiNICKind, nicList)...), env, nil
 	}
+	nicDevices := ngm.nicDeviceNodes(nicList)
+	if ngm.AllocationMode == AllocationModeMounts {
+		return append(mounts, ngm.driverMounts()...), append(ngm.deviceNodes(gpuList), nicDevices...), env, nil
+	}
+
+	env["NVIDIA_VISIBLE_DEVICES"] = strings.Join(gpuList, ",")
 
-	return nil, nil, env, nil
+	if len(nicDevices) > 0 {
+		return mounts, nicDevices, env, nil
+	}
+	return mounts, nil, env, nil
 }
 
 // AllocateGPU returns MountName, MountDriver, and list of Devices to use
 func (ngm *NvidiaGPUManager) AllocateOld(pod *types.PodInfo, container *types.ContainerInfo) ([]devtypes.Mount, []string, map[string]string, error) {
 	gpuList := []string{}
-	// volumeDriver := ""
-	// volumeName := ""
 	ngm.Lock()
 	defer ngm.Unlock()
 
@@ -292,15 +624,11 @@ func (ngm *NvidiaGPUManager) AllocateOld(pod *types.PodInfo, container *types.Co
 					gpuList = append(gpuList, val) // for other devices, e.g. /dev/nvidiactl, /dev/nvidia-uvm, /dev/nvidia-uvm-tools
 				}
 			}
-			// } else if key == `--volume-driver` {
-			// 	volumeDriver = val
-			// } else if key == `--volume` {
-			// 	volumeName = val
-			// }
+			// the nvidia-docker driver volume is replaced by mounts of the driver files
 		}
 	}
 
-	return nil, gpuList, nil, nil
+	return ngm.driverMounts(), gpuList, nil, nil
 }
 
 // numAllocateFrom := len(cont.AllocateFrom) // may be zero from old scheduler
@@ -2,11 +2,21 @@ package nvidia
 
 import (
 	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	devtypes "github.com/Microsoft/KubeDevice-API/pkg/device"
 	"github.com/Microsoft/KubeDevice-API/pkg/types"
 	gputypes "github.com/Microsoft/KubeGPU/gpuplugintypes"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/fixtures"
 	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
 
 	"strconv"
@@ -35,17 +45,83 @@ func assertMapEqual(t *testing.T, cap types.ResourceList, capExpected map[string
 	}
 }
 
+// addVersionResources adds the node version attributes advertised for info
+func addVersionResources(capExpected map[string]int64, info *nvgputypes.GpusInfo) {
+	if cuda, err := gputypes.EncodeCUDAVersion(info.Version.CUDA); err == nil {
+		capExpected[string(gputypes.AttributeCUDAVersion)] = cuda
+	}
+	if driver, err := gputypes.EncodeDriverVersion(info.Version.Driver); err == nil {
+		capExpected[string(gputypes.AttributeDriverVersion)] = driver
+	}
+}
+
+// allocatableExpected returns the expected capacity without the version attributes, which are not allocatable
+func allocatableExpected(capExpected map[string]int64) map[string]int64 {
+	allocExpected := make(map[string]int64)
+	for key, val := range capExpected {
+		if key != string(gputypes.AttributeCUDAVersion) && key != string(gputypes.AttributeDriverVersion) {
+			allocExpected[key] = val
+		}
+	}
+	return allocExpected
+}
+
 func setAllocFrom(info *nvgputypes.GpusInfo, allocFrom types.ResourceLocation, from int, to int) {
 	fromS := strconv.Itoa(from)
 	toS := info.Gpus[to].ID
 	fromLoc := types.ResourceName(string(types.DeviceGroupPrefix) + "/gpu/" + fromS + "/cards")
-	grp1 := to / 4
-	grp0 := to / 2
-	prefix := "/gpugrp1/" + strconv.Itoa(grp1) + "/gpugrp0/" + strconv.Itoa(grp0)
+	prefix := groupPrefix(info, (to/4)*4, (to/2)*2)
 	toLoc := types.ResourceName(string(types.DeviceGroupPrefix) + prefix + "/gpu/" + toS + "/cards")
 	allocFrom[fromLoc] = toLoc
 }
 
+// groupPrefix returns the group prefix of a GPU given the indices of the GPUs with lowest bus ID in its groups
+// for inventories without sysfs information, with one gpugrp1 group per NUMA node if the NUMA nodes are known, else
+// per CPU socket if the sockets are known
+func groupPrefix(info *nvgputypes.GpusInfo, grp1Leader int, grp0Leader int) string {
+	grp1 := groupIDFromBusID(info.Gpus[grp1Leader].PCI.BusID)
+	if node := info.Gpus[grp1Leader].NUMANode; node != nil {
+		grp1 = "numa" + strconv.FormatInt(*node, 10)
+	} else if socket := info.Gpus[grp1Leader].CPUAffinity; socket != nil {
+		grp1 = "socket" + strconv.FormatInt(*socket, 10)
+	}
+	return "/gpugrp1/" + grp1 + "/gpugrp0/" + groupIDFromBusID(info.Gpus[grp0Leader].PCI.BusID)
+}
+
+// addPCIDevice adds a PCI device with the given ancestry and NUMA node to a fake sysfs, returns its directory
+func addPCIDevice(sysfs string, pciPath string, numaNode string) string {
+	dir := filepath.Join(sysfs, "devices", pciPath)
+	os.MkdirAll(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "numa_node"), []byte(numaNode+"\n"), 0644)
+	os.MkdirAll(filepath.Join(sysfs, "bus", "pci", "devices"), 0755)
+	os.Symlink(dir, filepath.Join(sysfs, "bus", "pci", "devices", filepath.Base(pciPath)))
+	return dir
+}
+
+// jsonStringSysfs returns a fake sysfs with the GPUs of jsonString, pairs behind PCIe switches, two switches per socket
+func jsonStringSysfs(t *testing.T) string {
+	sysfs, err := ioutil.TempDir("", "nvidiasysfs")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	addPCIDevice(sysfs, "pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:00.0/0000:04:00.0", "0")
+	addPCIDevice(sysfs, "pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:01.0/0000:05:00.0", "0")
+	addPCIDevice(sysfs, "pci0000:00/0000:00:02.0/0000:03:00.0/0000:07:00.0/0000:08:00.0", "0")
+	addPCIDevice(sysfs, "pci0000:00/0000:00:02.0/0000:03:00.0/0000:07:01.0/0000:09:00.0", "0")
+	addPCIDevice(sysfs, "pci0000:80/0000:80:01.0/0000:81:00.0/0000:82:00.0/0000:85:00.0", "1")
+	addPCIDevice(sysfs, "pci0000:80/0000:80:01.0/0000:81:00.0/0000:82:01.0/0000:86:00.0", "1")
+	addPCIDevice(sysfs, "pci0000:80/0000:80:02.0/0000:87:00.0/0000:88:00.0/0000:89:00.0", "1")
+	addPCIDevice(sysfs, "pci0000:80/0000:80:02.0/0000:87:00.0/0000:88:01.0/0000:8a:00.0", "1")
+	return sysfs
+}
+
+// jsonStringSysfsPrefix returns the group prefix of GPU i of jsonString with jsonStringSysfs, groups are named after
+// the NUMA node and the upstream port of the PCIe switch
+func jsonStringSysfsPrefix(i int) string {
+	switches := []string{"0000_01_00_0", "0000_03_00_0", "0000_81_00_0", "0000_87_00_0"}
+	return "/gpugrp1/numa" + strconv.Itoa(i/4) + "/gpugrp0/" + switches[i/2]
+}
+
 func checkElemEqual(t *testing.T, a1 []string, a2 []string) {
 	if len(a1) != len(a2) {
 		t.Errorf("Lengths don't match %v vs %v", len(a1), len(a2))
@@ -117,11 +193,9 @@ func TestAlloc(t *testing.T) {
 	// test capacity returned
 	capExpected := make(map[string]int64)
 	capExpected[string(gputypes.ResourceGPU)] = int64(len(info.Gpus))
+	addVersionResources(capExpected, &info)
 	for i := 0; i < len(info.Gpus); i++ {
-		grp1 := i / 4
-		//grp0 := (i / 2) % 2
-		grp0 := i / 2
-		prefix := "/gpugrp1/" + strconv.Itoa(grp1) + "/gpugrp0/" + strconv.Itoa(grp0)
+		prefix := groupPrefix(&info, (i/4)*4, (i/2)*2)
 		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/cards"] = 1
 		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/memory"] = info.Gpus[i].Memory.Global * int64(1024) * int64(1024)
 	}
@@ -137,8 +211,10 @@ func TestAlloc(t *testing.T) {
 	cap = nodeInfo.Capacity
 	capExpected = make(map[string]int64)
 	capExpected[string(gputypes.ResourceGPU)] = int64(len(info.Gpus))
+	addVersionResources(capExpected, &info)
+	// all GPUs are on NUMA node 0 and not linked, so the groups are named after the bus IDs
 	for i := 0; i < len(info.Gpus); i++ {
-		prefix := "/gpugrp1/" + strconv.Itoa(i) + "/gpugrp0/" + strconv.Itoa(i)
+		prefix := "/gpugrp1/" + groupIDFromBusID(info.Gpus[i].PCI.BusID) + "/gpugrp0/" + groupIDFromBusID(info.Gpus[i].PCI.BusID)
 		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/cards"] = 1
 		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/memory"] = info.Gpus[i].Memory.Global * int64(1024) * int64(1024)
 	}
@@ -148,3 +224,1152 @@ func TestAlloc(t *testing.T) {
 	alloc := map[int]int{4: 2, 3: 0, 5: 1}
 	testAlloc(t, ngm, &info, alloc)
 }
+
+func TestHealth(t *testing.T) {
+	var info nvgputypes.GpusInfo
+	err := json.Unmarshal([]byte(jsonString), &info)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	ngm, err := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	nodeInfo := types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+
+	now := time.Now()
+	// application XIDs and retired pages below the threshold are not critical
+	nvidiaManager.handleHealthEvent(&nvgputypes.HealthEvent{UUID: "GPU01", Type: nvgputypes.HealthEventXid, Data: 31}, now)
+	nvidiaManager.pollRetiredPages(fakeRetiredPages{"GPU02": {Count: 10}}, now)
+	// critical events
+	nvidiaManager.handleHealthEvent(&nvgputypes.HealthEvent{UUID: "GPU03", Type: nvgputypes.HealthEventXid, Data: 79}, now)
+	nvidiaManager.handleHealthEvent(&nvgputypes.HealthEvent{UUID: "GPU05", Type: nvgputypes.HealthEventDoubleBitECC}, now)
+
+	// node level resources are the GPU count and the CUDA and driver version attributes, which are not allocatable
+	nodeInfo = types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	if len(nodeInfo.Capacity) != 2*len(info.Gpus)+3 {
+		t.Errorf("Capacity should be unchanged, have %v", nodeInfo.Capacity)
+	}
+	if len(nodeInfo.Allocatable) != 2*(len(info.Gpus)-2)+1 {
+		t.Errorf("Two devices should be removed from allocatable, have %v", nodeInfo.Allocatable)
+	}
+	for res := range nodeInfo.Allocatable {
+		if strings.Contains(string(res), "/gpu/GPU03/") || strings.Contains(string(res), "/gpu/GPU05/") {
+			t.Errorf("Unhealthy device resource %v is allocatable", res)
+		}
+	}
+
+	// devices recover after the quiet period
+	nvidiaManager.recoverDevices(now.Add(nvidiaManager.HealthRecoveryPeriod))
+	nodeInfo = types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	if len(nodeInfo.Allocatable) != 2*len(info.Gpus)+1 {
+		t.Errorf("Devices should have recovered, have %v", nodeInfo.Allocatable)
+	}
+
+	// retired pages at the threshold, or pending retirement, make devices unhealthy
+	nvidiaManager.RetiredPagesThreshold = 20
+	nvidiaManager.pollRetiredPages(fakeRetiredPages{"GPU02": {Count: 20}, "GPU04": {Count: 1, Pending: true}, "GPU06": {Count: 19}}, now)
+	nodeInfo = types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	if len(nodeInfo.Allocatable) != 2*(len(info.Gpus)-2)+1 {
+		t.Errorf("Two devices should be removed from allocatable, have %v", nodeInfo.Allocatable)
+	}
+	if nvidiaManager.gpus["GPU02"].HealthReason != "20 retired pages" || !nvidiaManager.gpus["GPU04"].Unhealthy || nvidiaManager.gpus["GPU06"].Unhealthy {
+		t.Errorf("Wrong health after polling retired pages, have %+v", nvidiaManager.gpus)
+	}
+}
+
+type fakeRetiredPages map[string]nvgputypes.RetiredPages
+
+func (f fakeRetiredPages) RetiredPages() (map[string]nvgputypes.RetiredPages, error) {
+	return f, nil
+}
+
+func TestInventoryEvents(t *testing.T) {
+	var info nvgputypes.GpusInfo
+	err := json.Unmarshal([]byte(jsonString), &info)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	ngm, err := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	events, cancel := nvidiaManager.Subscribe(100)
+	defer cancel()
+	collect := func() map[InventoryEventType][]string {
+		got := make(map[InventoryEventType][]string)
+		for {
+			select {
+			case event := <-events:
+				got[event.Type] = append(got[event.Type], event.ID)
+			default:
+				return got
+			}
+		}
+	}
+
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+	got := collect()
+	if len(got) != 1 || len(got[GPUAdded]) != len(info.Gpus) {
+		t.Errorf("Expected %v GPUAdded events, have %v", len(info.Gpus), got)
+	}
+
+	// remove the last GPU and upgrade the driver
+	fake := nvidiaManager.np.(*NvidiaFakePlugin)
+	fake.gInfo.Gpus = fake.gInfo.Gpus[:len(fake.gInfo.Gpus)-1]
+	fake.gInfo.Version.Driver = "384.111"
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+	got = collect()
+	checkElemEqual(t, got[GPURemoved], []string{"GPU07"})
+	checkElemEqual(t, got[DriverVersionChanged], []string{""})
+	if len(got[GPUAdded]) != 0 {
+		t.Errorf("Unexpected GPUAdded events %v", got[GPUAdded])
+	}
+
+	nvidiaManager.handleHealthEvent(&nvgputypes.HealthEvent{UUID: "GPU01", Type: nvgputypes.HealthEventDoubleBitECC}, time.Now())
+	got = collect()
+	checkElemEqual(t, got[GPUHealthChanged], []string{"GPU01"})
+}
+
+func TestRefreshInterval(t *testing.T) {
+	var info nvgputypes.GpusInfo
+	err := json.Unmarshal([]byte(jsonString), &info)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	ngm, err := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	if nvidiaManager.RefreshInterval != 0 {
+		t.Errorf("Expected no refresh interval by default, have %v", nvidiaManager.RefreshInterval)
+	}
+	// devices discovered once are cached forever without a refresh interval, NVML discovery is not available in tests
+	nvidiaManager.useNVML = true
+	nvidiaManager.GpusInfo = &info
+	for _, interval := range []time.Duration{0, -time.Minute} {
+		nvidiaManager.RefreshInterval = interval
+		if err := nvidiaManager.updateGPUInfo(false); err != nil {
+			t.Errorf("Devices should be cached with refresh interval %v, have %v", interval, err)
+		}
+	}
+	nvidiaManager.StartRefresh()
+	if nvidiaManager.refreshStop != nil {
+		t.Errorf("No background refresh should be started")
+	}
+}
+
+func TestStableGroupNames(t *testing.T) {
+	if groupIDFromBusID("00000000:8A:00.0") != "0000_8a_00_0" || groupIDFromBusID("0000:8a:00.0") != "0000_8a_00_0" {
+		t.Errorf("Bus IDs not normalized, have %v and %v", groupIDFromBusID("00000000:8A:00.0"), groupIDFromBusID("0000:8a:00.0"))
+	}
+
+	sysfs := jsonStringSysfs(t)
+	defer os.RemoveAll(sysfs)
+	groups := func(info nvgputypes.GpusInfo, sysfsRoot string) types.ResourceList {
+		ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+		ngm.(*NvidiaGPUManager).SysfsRoot = sysfsRoot
+		nodeInfo := types.NewNodeInfo()
+		ngm.UpdateNodeInfo(nodeInfo)
+		return nodeInfo.Capacity
+	}
+	var info nvgputypes.GpusInfo
+	err := json.Unmarshal([]byte(jsonString), &info)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	// reversed enumeration order with GPU00 missing, it has the lowest bus ID of its groups
+	var reordered nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &reordered)
+	for i, j := 0, len(reordered.Gpus)-1; i < j; i, j = i+1, j-1 {
+		reordered.Gpus[i], reordered.Gpus[j] = reordered.Gpus[j], reordered.Gpus[i]
+	}
+	reordered.Gpus = reordered.Gpus[:len(reordered.Gpus)-1]
+
+	// all GPUs keep their names, including GPU01 in the groups of the missing GPU
+	capacity := groups(info, sysfs)
+	capacity2 := groups(reordered, sysfs)
+	if len(capacity2) != len(capacity)-2 {
+		t.Errorf("Expected the resources of GPU00 to be removed, have %v", capacity2)
+	}
+	for res, val := range capacity {
+		if strings.HasPrefix(string(res), types.DeviceGroupPrefix) && !strings.Contains(string(res), "/gpu/GPU00/") && capacity2[res] != val {
+			t.Errorf("Resource %v changed name or value", res)
+		}
+	}
+	gpu01 := types.ResourceName(types.DeviceGroupPrefix + jsonStringSysfsPrefix(1) + "/gpu/GPU01/cards")
+	if capacity2[gpu01] != 1 {
+		t.Errorf("Expected %v, have %v", gpu01, capacity2)
+	}
+
+	// without sysfs, the gpugrp1 groups are named after the CPU sockets and gpugrp0 groups after the lowest bus ID when
+	// first discovered, GPU01 keeps the name of its group after GPU00
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nodeInfo := types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	capacity = nodeInfo.Capacity
+	ngm.(*NvidiaGPUManager).np.(*NvidiaFakePlugin).gInfo = reordered
+	nodeInfo = types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	capacity2 = nodeInfo.Capacity
+	for res, val := range capacity {
+		if strings.HasPrefix(string(res), types.DeviceGroupPrefix) && !strings.Contains(string(res), "/gpu/GPU00/") && capacity2[res] != val {
+			t.Errorf("Resource %v changed name or value", res)
+		}
+	}
+	gpu01 = types.ResourceName(types.DeviceGroupPrefix + groupPrefix(&info, 0, 0) + "/gpu/GPU01/cards")
+	if capacity2[gpu01] != 1 {
+		t.Errorf("Expected %v, have %v", gpu01, capacity2)
+	}
+}
+
+func TestLostGPUs(t *testing.T) {
+	var info nvgputypes.GpusInfo
+	err := json.Unmarshal([]byte(jsonString), &info)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+
+	// GPU06 and GPU07 disappear, GPU07 is in use
+	gpu := nvidiaManager.gpus["GPU07"]
+	gpu.InUse = true
+	nvidiaManager.gpus["GPU07"] = gpu
+	fake := nvidiaManager.np.(*NvidiaFakePlugin)
+	fake.gInfo.Gpus = fake.gInfo.Gpus[:len(fake.gInfo.Gpus)-2]
+	nodeInfo := types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	if nodeInfo.Capacity[gputypes.ResourceGPU] != int64(len(info.Gpus)-2) {
+		t.Errorf("Lost GPUs should not be counted, have %v", nodeInfo.Capacity[gputypes.ResourceGPU])
+	}
+	lost := nvidiaManager.LostGPUs()
+	if len(lost) != 2 || lost[0].ID != "GPU06" || lost[0].LostSince.IsZero() {
+		t.Errorf("Expected GPU06 and GPU07 to be lost, have %+v", lost)
+	}
+	conds := nvidiaManager.Conditions()
+	if len(conds) != 1 || conds[0].Type != GPULostInUse || conds[0].ID != "GPU07" {
+		t.Errorf("Expected GPU07 lost in use condition, have %+v", conds)
+	}
+
+	// GPUs found again are no longer lost
+	fake.gInfo = info
+	ngm.UpdateNodeInfo(nodeInfo)
+	if len(nvidiaManager.LostGPUs()) != 0 || len(nvidiaManager.Conditions()) != 0 {
+		t.Errorf("GPUs should be found again")
+	}
+}
+
+func TestAllocateBookkeeping(t *testing.T) {
+	var info nvgputypes.GpusInfo
+	err := json.Unmarshal([]byte(jsonString), &info)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+
+	newContainer := func(alloc map[int]int) *types.ContainerInfo {
+		container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+		for from, to := range alloc {
+			setAllocFrom(&info, container.AllocateFrom, from, to)
+		}
+		return &container
+	}
+
+	contA := newContainer(map[int]int{0: 0, 1: 1})
+	_, _, env, err := nvidiaManager.AllocateContainer("A", "main", contA)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	if env["NVIDIA_VISIBLE_DEVICES"] != "GPU00,GPU01" {
+		t.Errorf("Wrong visible devices %v", env["NVIDIA_VISIBLE_DEVICES"])
+	}
+	if !nvidiaManager.gpus["GPU00"].InUse || !nvidiaManager.gpus["GPU01"].InUse || nvidiaManager.gpus["GPU02"].InUse {
+		t.Errorf("InUse not set correctly")
+	}
+	if !reflect.DeepEqual(nvidiaManager.Assignments(), map[string]map[string][]string{"A": {"main": {"GPU00", "GPU01"}}}) {
+		t.Errorf("Wrong assignments %v", nvidiaManager.Assignments())
+	}
+	// allocating the same container again is allowed
+	if _, _, _, err = nvidiaManager.AllocateContainer("A", "main", contA); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+
+	// double allocation
+	contB := newContainer(map[int]int{0: 1, 1: 2})
+	if _, _, _, err = nvidiaManager.AllocateContainer("B", "main", contB); err == nil {
+		t.Errorf("Expected error for GPU already assigned")
+	}
+	if nvidiaManager.gpus["GPU02"].InUse {
+		t.Errorf("Failed allocation should not assign GPUs")
+	}
+	// unknown device
+	contC := newContainer(map[int]int{})
+	contC.AllocateFrom["resource/group/gpu/0/cards"] = "resource/group/gpugrp1/A/gpugrp0/B/gpu/GPU99/cards"
+	if _, _, _, err = nvidiaManager.AllocateContainer("C", "main", contC); err == nil {
+		t.Errorf("Expected error for unknown GPU")
+	}
+
+	nvidiaManager.ReleasePod("A")
+	if nvidiaManager.gpus["GPU00"].InUse || nvidiaManager.gpus["GPU01"].InUse {
+		t.Errorf("InUse not cleared on release")
+	}
+	if _, _, _, err = nvidiaManager.AllocateContainer("B", "main", contB); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	nvidiaManager.ReleaseContainer("B", "main")
+	if len(nvidiaManager.Assignments()) != 0 || nvidiaManager.gpus["GPU01"].InUse {
+		t.Errorf("Container not released, have %v", nvidiaManager.Assignments())
+	}
+
+	// pods of the same name in different namespaces are different owners
+	if _, _, _, err = nvidiaManager.AllocateContainer("ns1/A", "main", contA); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	if _, _, _, err = nvidiaManager.AllocateContainer("ns2/A", "main", contA); err == nil {
+		t.Errorf("Expected error for GPU already assigned to a pod in another namespace")
+	}
+	nvidiaManager.ReleasePod("ns1/A")
+
+	// through the Device interface, the container is keyed by its GPUs, shared with an init container
+	pod := &types.PodInfo{Name: "D", InitContainers: map[string]types.ContainerInfo{"init": *contA},
+		RunningContainers: map[string]types.ContainerInfo{"main": *contA}}
+	for _, cont := range []types.ContainerInfo{pod.InitContainers["init"], pod.RunningContainers["main"]} {
+		if _, _, _, err = ngm.Allocate(pod, &cont); err != nil {
+			t.Errorf("Got error %v", err)
+		}
+	}
+	if !reflect.DeepEqual(nvidiaManager.Assignments(), map[string]map[string][]string{"D": {"gpus:GPU00,GPU01": {"GPU00", "GPU01"}}}) {
+		t.Errorf("Wrong assignments %v", nvidiaManager.Assignments())
+	}
+}
+
+func TestReleaseEndedPods(t *testing.T) {
+	var info nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info)
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	var pods []PodRef
+	nvidiaManager.LivePods = func() ([]PodRef, error) {
+		return pods, nil
+	}
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+	allocate := func(podName string, to int) error {
+		container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+		setAllocFrom(&info, container.AllocateFrom, 0, to)
+		pod := &types.PodInfo{Name: podName, RunningContainers: map[string]types.ContainerInfo{"main": container}}
+		_, _, _, err := ngm.Allocate(pod, &container)
+		return err
+	}
+
+	pods = []PodRef{{Namespace: "ns1", Name: "A", UID: "uid-a"}, {Namespace: "ns1", Name: "B", UID: "uid-b"}}
+	if err := allocate("A", 0); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	if err := allocate("B", 0); err == nil {
+		t.Errorf("Expected error for GPU assigned to a live pod")
+	}
+	// pod A ends, its GPU is given to pod B
+	pods = []PodRef{{Namespace: "ns1", Name: "B", UID: "uid-b"}}
+	if err := allocate("B", 0); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	expected := map[string]map[string][]string{"uid-b": {"gpus:GPU00": {"GPU00"}}}
+	if !reflect.DeepEqual(nvidiaManager.Assignments(), expected) {
+		t.Errorf("Wrong assignments, expected %v have %v", expected, nvidiaManager.Assignments())
+	}
+
+	// pods of the same name in different namespaces are different owners
+	pods = []PodRef{{Namespace: "ns1", Name: "A", UID: "uid-a1"}, {Namespace: "ns2", Name: "A", UID: "uid-a2"}}
+	for _, to := range []int{1, 2, 1} {
+		if err := allocate("A", to); err != nil {
+			t.Errorf("Got error %v", err)
+		}
+	}
+	// pod B has ended too, garbage collection releases its GPU
+	if err := nvidiaManager.GarbageCollect(); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	expected = map[string]map[string][]string{"uid-a1": {"gpus:GPU01": {"GPU01"}}, "uid-a2": {"gpus:GPU02": {"GPU02"}}}
+	if !reflect.DeepEqual(nvidiaManager.Assignments(), expected) {
+		t.Errorf("Wrong assignments, expected %v have %v", expected, nvidiaManager.Assignments())
+	}
+	if nvidiaManager.gpus["GPU00"].InUse {
+		t.Errorf("GPU of ended pod B should be released")
+	}
+
+	// pods are listed through the kubelet, terminated pods are not alive
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[{"metadata":{"name":"A","namespace":"ns1","uid":"uid-a"},"status":{"phase":"Running"}},` +
+			`{"metadata":{"name":"B","namespace":"ns1","uid":"uid-b"},"status":{"phase":"Succeeded"}}]}`))
+	}))
+	defer server.Close()
+	livePods, err := KubeletLivePods(server.URL + "/pods")()
+	if err != nil || !reflect.DeepEqual(livePods, []PodRef{{Namespace: "ns1", Name: "A", UID: "uid-a"}}) {
+		t.Errorf("Wrong live pods %v err %v", livePods, err)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nvidiacheckpoint")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	checkpointPath := filepath.Join(dir, "checkpoint.json")
+
+	var info nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info)
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	nvidiaManager.CheckpointPath = checkpointPath
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+	for podName, to := range map[string]int{"A": 0, "B": 6, "C": 7} {
+		container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+		setAllocFrom(&info, container.AllocateFrom, 0, to)
+		if _, _, _, err := nvidiaManager.AllocateContainer(podName, "main", &container); err != nil {
+			t.Errorf("Got error %v", err)
+		}
+	}
+
+	// restart with GPU07 gone and pod B failed, the stale entries of the checkpoint are dropped
+	var info2 nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info2)
+	info2.Gpus = info2.Gpus[:7]
+	ngm2, _ := NewFakeNvidiaGPUManager(&info2, volumeName, volumeDriver)
+	nvidiaManager2 := ngm2.(*NvidiaGPUManager)
+	nvidiaManager2.CheckpointPath = checkpointPath
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[{"metadata":{"name":"A","namespace":"default","uid":"A"},"status":{"phase":"Running"}},` +
+			`{"metadata":{"name":"B","namespace":"default","uid":"B"},"status":{"phase":"Failed"}},` +
+			`{"metadata":{"name":"C","namespace":"default","uid":"C"},"status":{"phase":"Running"}}]}`))
+	}))
+	defer server.Close()
+	nvidiaManager2.LivePods = KubeletLivePods(server.URL + "/pods")
+	ngm2.Start()
+	defer nvidiaManager2.Stop()
+	expected := map[string]map[string][]string{"A": {"main": {"GPU00"}}}
+	if !reflect.DeepEqual(nvidiaManager2.Assignments(), expected) {
+		t.Errorf("Wrong assignments after restart, expected %v have %v", expected, nvidiaManager2.Assignments())
+	}
+	if !nvidiaManager2.gpus["GPU00"].InUse || nvidiaManager2.gpus["GPU06"].InUse {
+		t.Errorf("InUse not restored correctly")
+	}
+	data, err := readCheckpoint(checkpointPath)
+	if err != nil || !reflect.DeepEqual(data.Assignments, expected) {
+		t.Errorf("Checkpoint not rewritten, have %v err %v", data, err)
+	}
+
+	// corrupted checkpoint is rejected
+	body, _ := ioutil.ReadFile(checkpointPath)
+	ioutil.WriteFile(checkpointPath, []byte(strings.Replace(string(body), "GPU00", "GPU01", 1)), 0644)
+	if _, err := readCheckpoint(checkpointPath); err == nil {
+		t.Errorf("Expected checksum error")
+	}
+}
+
+func TestCDI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nvidiacdi")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var info nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info)
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	nvidiaManager.AllocationMode = AllocationModeCDI
+	nvidiaManager.CDISpecDir = dir
+	nvidiaManager.DriverHostPath = "/opt/nvidia/driver"
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+
+	body, err := ioutil.ReadFile(filepath.Join(dir, cdiSpecFileName))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	var spec cdiSpec
+	if err := json.Unmarshal(body, &spec); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if spec.Kind != "kubegpu.microsoft.com/gpu" || len(spec.Devices) != len(info.Gpus) {
+		t.Errorf("Unexpected spec %+v", spec)
+	}
+	if spec.Devices[3].Name != "GPU03" || spec.Devices[3].ContainerEdits.DeviceNodes[0].Path != "/dev/nvidia3" {
+		t.Errorf("Unexpected device %+v", spec.Devices[3])
+	}
+	if len(spec.ContainerEdits.DeviceNodes) != 3 || len(spec.ContainerEdits.Mounts) != 1 ||
+		spec.ContainerEdits.Mounts[0].HostPath != "/opt/nvidia/driver" || spec.ContainerEdits.Mounts[0].ContainerPath != "/usr/local/nvidia" {
+		t.Errorf("Unexpected common container edits %+v", spec.ContainerEdits)
+	}
+
+	container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+	setAllocFrom(&info, container.AllocateFrom, 0, 2)
+	setAllocFrom(&info, container.AllocateFrom, 1, 3)
+	pod := &types.PodInfo{Name: "A", RunningContainers: map[string]types.ContainerInfo{"main": container}}
+	_, devices, env, err := ngm.Allocate(pod, &container)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	checkElemEqual(t, devices, []string{"kubegpu.microsoft.com/gpu=GPU02", "kubegpu.microsoft.com/gpu=GPU03"})
+	if len(env) != 0 {
+		t.Errorf("Unexpected env %v", env)
+	}
+}
+
+func TestDriverMounts(t *testing.T) {
+	root, err := ioutil.TempDir("", "nvidiadriver")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer os.RemoveAll(root)
+	files := []string{
+		"/usr/lib/x86_64-linux-gnu/libcuda.so.375.20",
+		"/usr/lib/x86_64-linux-gnu/libcuda.so.370.00", // different version, not mounted
+		"/usr/lib64/libnvidia-ml.so.375.20",
+		"/usr/lib64/libnvidia-compiler.so.375.20",
+		"/usr/bin/nvidia-smi",
+	}
+	for _, file := range files {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(file)), 0755)
+		ioutil.WriteFile(filepath.Join(root, file), []byte{}, 0644)
+	}
+
+	var info nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info)
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	nvidiaManager.AllocationMode = AllocationModeMounts
+	nvidiaManager.DriverRoot = root
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+
+	container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+	setAllocFrom(&info, container.AllocateFrom, 0, 5)
+	pod := &types.PodInfo{Name: "A", RunningContainers: map[string]types.ContainerInfo{"main": container}}
+	mounts, devices, env, err := ngm.Allocate(pod, &container)
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	checkElemEqual(t, devices, []string{"/dev/nvidia5", "/dev/nvidiactl", "/dev/nvidia-uvm", "/dev/nvidia-uvm-tools"})
+	// the search paths of the image are kept
+	if _, ok := env["LD_LIBRARY_PATH"]; ok {
+		t.Errorf("LD_LIBRARY_PATH of the image should be kept, have %v", env)
+	}
+	if _, ok := env["PATH"]; ok {
+		t.Errorf("PATH of the image should be kept, have %v", env)
+	}
+	expected := map[string]string{
+		"/usr/local/nvidia/lib64/libcuda.so.375.20":            "/usr/lib/x86_64-linux-gnu/libcuda.so.375.20",
+		"/usr/local/nvidia/lib64/libcuda.so.1":                 "/usr/lib/x86_64-linux-gnu/libcuda.so.375.20",
+		"/usr/local/nvidia/lib64/libnvidia-ml.so.375.20":       "/usr/lib64/libnvidia-ml.so.375.20",
+		"/usr/local/nvidia/lib64/libnvidia-ml.so.1":            "/usr/lib64/libnvidia-ml.so.375.20",
+		"/usr/local/nvidia/lib64/libnvidia-compiler.so.375.20": "/usr/lib64/libnvidia-compiler.so.375.20",
+		"/usr/local/nvidia/bin/nvidia-smi":                     "/usr/bin/nvidia-smi",
+	}
+	if len(mounts) != len(expected) {
+		t.Errorf("Expected %v mounts, have %+v", len(expected), mounts)
+	}
+	for _, mount := range mounts {
+		if expected[mount.ContainerPath] != mount.HostPath || !mount.Readonly {
+			t.Errorf("Unexpected mount %+v", mount)
+		}
+	}
+}
+
+func TestTopologyOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nvidiancclxml")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var info nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info)
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	nvidiaManager.NCCLHints = true
+	nvidiaManager.ManifestDir = dir
+	nvidiaManager.SysfsRoot = jsonStringSysfs(t)
+	defer os.RemoveAll(nvidiaManager.SysfsRoot)
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+
+	allocate := func(podName string, alloc map[int]int) (map[string]string, *ncclTopoSystem) {
+		container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+		for from, to := range alloc {
+			setAllocFrom(&info, container.AllocateFrom, from, to)
+		}
+		mounts, _, env, err := nvidiaManager.AllocateContainer(podName, "main", &container)
+		if err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+		if len(mounts) != 2 || mounts[1].ContainerPath != env["NCCL_TOPO_FILE"] || !mounts[1].Readonly {
+			t.Fatalf("Unexpected mounts %+v env %v", mounts, env)
+		}
+		body, _ := ioutil.ReadFile(mounts[1].HostPath)
+		var system ncclTopoSystem
+		if err := xml.Unmarshal(body, &system); err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+		return env, &system
+	}
+
+	// requests in scrambled order are returned in topology order
+	env, system := allocate("A", map[int]int{0: 5, 1: 0, 2: 4, 3: 1})
+	if env["NVIDIA_VISIBLE_DEVICES"] != "GPU00,GPU01,GPU04,GPU05" {
+		t.Errorf("Devices not in topology order %v", env["NVIDIA_VISIBLE_DEVICES"])
+	}
+	if _, available := env["NCCL_P2P_LEVEL"]; available {
+		t.Errorf("NCCL should detect the P2P level, have %v", env)
+	}
+	// GPUs behind their PCIe switches on their NUMA nodes read from sysfs, numbered in the order they are visible
+	if env["NCCL_TOPO_FILE"] != "/etc/kubegpu/nccl-topo.xml" || len(system.CPUs) != 2 || system.CPUs[0].NUMAID != 0 ||
+		len(system.CPUs[0].PCIs) != 1 || system.CPUs[1].NUMAID != 1 || len(system.CPUs[1].PCIs) != 1 || len(system.CPUs[1].PCIs[0].PCIs) != 2 {
+		t.Fatalf("Unexpected NCCL topology %+v", system)
+	}
+	gpu := system.CPUs[1].PCIs[0].PCIs[1]
+	if gpu.BusID != "0000:86:00.0" || gpu.Class != ncclClassGPU || gpu.GPU == nil || gpu.GPU.Dev != 3 {
+		t.Errorf("Unexpected NCCL topology GPU %+v", gpu)
+	}
+	env, system = allocate("B", map[int]int{0: 3, 1: 2})
+	if env["NVIDIA_VISIBLE_DEVICES"] != "GPU02,GPU03" || len(system.CPUs) != 1 || len(system.CPUs[0].PCIs) != 1 || len(system.CPUs[0].PCIs[0].PCIs) != 2 {
+		t.Errorf("Unexpected env %v topology %+v", env, system)
+	}
+
+	// the NCCL topology is written to its own directory without manifests
+	nvidiaManager.ManifestDir = ""
+	nvidiaManager.NCCLTopoDir = filepath.Join(dir, "nccl")
+	container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+	setAllocFrom(&info, container.AllocateFrom, 0, 6)
+	mounts, _, env, err := nvidiaManager.AllocateContainer("C", "main", &container)
+	hostPath := filepath.Join(dir, "nccl", "C", "main.nccl.xml")
+	if err != nil || len(mounts) != 1 || mounts[0].HostPath != hostPath || env["NCCL_TOPO_FILE"] != mounts[0].ContainerPath {
+		t.Fatalf("Unexpected mounts %+v env %v error %v", mounts, env, err)
+	}
+	if _, err := os.Stat(hostPath); err != nil {
+		t.Errorf("NCCL topology not written, %v", err)
+	}
+	nvidiaManager.ReleasePod("C")
+	if _, err := os.Stat(hostPath); !os.IsNotExist(err) {
+		t.Errorf("NCCL topology of released pod not removed, %v", err)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nvidiamanifest")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var info nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info)
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	nvidiaManager.ManifestDir = dir
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+
+	container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+	setAllocFrom(&info, container.AllocateFrom, 0, 3)
+	setAllocFrom(&info, container.AllocateFrom, 1, 2)
+	mounts, _, _, err := nvidiaManager.AllocateContainer("ns/A", "main", &container)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if len(mounts) != 1 || mounts[0].ContainerPath != "/etc/kubegpu/allocation.json" || !mounts[0].Readonly {
+		t.Fatalf("Unexpected mounts %+v", mounts)
+	}
+	body, err := ioutil.ReadFile(mounts[0].HostPath)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	var manifest nvgputypes.AllocationManifest
+	json.Unmarshal(body, &manifest)
+	expected := nvgputypes.AllocationManifest{
+		Pod:       "ns/A",
+		Container: "main",
+		Gpus: []nvgputypes.ManifestGpu{
+			{UUID: "GPU02", BusID: "0000:08:00.0", Model: "GeForce GTX TITAN X", Memory: 12238 * 1024 * 1024, Group: groupPrefix(&info, 0, 2)[1:]},
+			{UUID: "GPU03", BusID: "0000:09:00.0", Model: "GeForce GTX TITAN X", Memory: 12238 * 1024 * 1024, Group: groupPrefix(&info, 0, 2)[1:]},
+		},
+		Links: []nvgputypes.ManifestLink{{From: "GPU02", To: "GPU03", Link: 5}, {From: "GPU03", To: "GPU02", Link: 5}},
+	}
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("Unexpected manifest\nHave:\n%+v\nExpect:\n%+v", manifest, expected)
+	}
+
+	nvidiaManager.ReleasePod("ns/A")
+	if _, err := os.Stat(mounts[0].HostPath); !os.IsNotExist(err) {
+		t.Errorf("Manifest not removed on release")
+	}
+
+	// releasing a container removes its manifest only, the pod directory goes with the last container
+	nvidiaManager.AllocateContainer("ns/A", "init", &container)
+	mounts, _, _, _ = nvidiaManager.AllocateContainer("ns/A", "main", &container)
+	nvidiaManager.ReleaseContainer("ns/A", "main")
+	if _, err := os.Stat(mounts[0].HostPath); !os.IsNotExist(err) {
+		t.Errorf("Manifest not removed on container release")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ns_A", "init.json")); err != nil {
+		t.Errorf("Manifest of other container removed, %v", err)
+	}
+	nvidiaManager.ReleaseContainer("ns/A", "init")
+	if _, err := os.Stat(filepath.Join(dir, "ns_A")); !os.IsNotExist(err) {
+		t.Errorf("Pod manifest directory not removed")
+	}
+}
+
+func TestGroupingPolicy(t *testing.T) {
+	invalid := []GroupingPolicy{
+		{Levels: [][]int32{{6, 5, 4}}},
+		{Levels: [][]int32{{6, 5, 4}, {6, 5, 3}}},
+		{Levels: [][]int32{{8}, {8, 7}}},
+		{Levels: [][]int32{{7}, {7, 6, 5}, {7, 6, 5, 4, 3, 2, 1}}},
+	}
+	for _, policy := range invalid {
+		if policy.Validate() == nil {
+			t.Errorf("Expected policy %v to be invalid", policy)
+		}
+	}
+	if err := DefaultGroupingPolicy().Validate(); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "nvidiagrouping")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	policyPath := filepath.Join(dir, "policy.json")
+	ioutil.WriteFile(policyPath, []byte(`{"Levels": [[7], [7, 6, 5], [7, 6, 5, 4, 3, 2, 1]]}`), 0644)
+	if _, err := LoadGroupingPolicy(policyPath); err == nil || !strings.Contains(err.Error(), "exactly 2 levels") {
+		t.Errorf("Expected error for three levels, have %v", err)
+	}
+	ioutil.WriteFile(policyPath, []byte(`{"Levels": [[6, 5, 4, 3], [6, 5, 4, 3, 2, 1]]}`), 0644)
+	policy, err := LoadGroupingPolicy(policyPath)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+
+	// host bridge peers form the first level group
+	var info nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info)
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	ngm.(*NvidiaGPUManager).GroupingPolicy = policy
+	nodeInfo := types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	capExpected := make(map[string]int64)
+	capExpected[string(gputypes.ResourceGPU)] = int64(len(info.Gpus))
+	addVersionResources(capExpected, &info)
+	for i := 0; i < len(info.Gpus); i++ {
+		prefix := groupPrefix(&info, (i/4)*4, (i/4)*4)
+		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/cards"] = 1
+		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/memory"] = info.Gpus[i].Memory.Global * int64(1024) * int64(1024)
+	}
+	assertMapEqual(t, nodeInfo.Capacity, capExpected)
+}
+
+func TestNVLinkTopology(t *testing.T) {
+	// four GPUs on one host bridge, with NVLinks between GPU0-GPU1 and GPU2-GPU3
+	info := nvgputypes.GpusInfo{Version: nvgputypes.VersionInfo{Driver: "418.87.01"}}
+	for i := 0; i < 4; i++ {
+		gpu := nvgputypes.GpuInfo{
+			ID:     "GPU0" + strconv.Itoa(i),
+			Path:   "/dev/nvidia" + strconv.Itoa(i),
+			Memory: nvgputypes.MemoryInfo{Global: 16160},
+			PCI:    nvgputypes.PciInfo{BusID: "0000:0" + strconv.Itoa(i) + ":00.0"},
+		}
+		for j := 0; j < 4; j++ {
+			if i != j {
+				gpu.Topology = append(gpu.Topology, nvgputypes.TopologyInfo{BusID: "0000:0" + strconv.Itoa(j) + ":00.0", Link: 3})
+			}
+		}
+		peer := i ^ 1
+		gpu.NVLinks = []nvgputypes.NVLinkInfo{{BusID: "0000:0" + strconv.Itoa(peer) + ":00.0", Links: 2, Bandwidth: 50000000000}}
+		info.Gpus = append(info.Gpus, gpu)
+	}
+	body, _ := json.Marshal(&info)
+	var info2 nvgputypes.GpusInfo
+	json.Unmarshal(body, &info2)
+	if !reflect.DeepEqual(info2.Gpus[0].NVLinks, info.Gpus[0].NVLinks) {
+		t.Errorf("NVLinks not preserved by JSON format, have %+v", info2.Gpus[0].NVLinks)
+	}
+
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nodeInfo := types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	capExpected := make(map[string]int64)
+	capExpected[string(gputypes.ResourceGPU)] = int64(len(info.Gpus))
+	addVersionResources(capExpected, &info)
+	for i := 0; i < len(info.Gpus); i++ {
+		prefix := groupPrefix(&info, 0, (i/2)*2)
+		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/cards"] = 1
+		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/memory"] = info.Gpus[i].Memory.Global * int64(1024) * int64(1024)
+	}
+	assertMapEqual(t, nodeInfo.Capacity, capExpected)
+}
+
+func TestFullyConnected(t *testing.T) {
+	// eight GPUs behind NVSwitches, PCIe switches pair them up, NVML reports the links to the switches only
+	info := nvgputypes.GpusInfo{Version: nvgputypes.VersionInfo{Driver: "418.87.01"}}
+	busID := func(i int) string { return "0000:" + strconv.Itoa(10+i) + ":00.0" }
+	for i := 0; i < 8; i++ {
+		gpu := nvgputypes.GpuInfo{ID: "GPU0" + strconv.Itoa(i), Path: "/dev/nvidia" + strconv.Itoa(i), PCI: nvgputypes.PciInfo{BusID: busID(i)},
+			NVSwitchLinks: 6}
+		for j := 0; j < 8; j++ {
+			if i != j {
+				link := int32(3)
+				if i/2 == j/2 {
+					link = 5
+				}
+				gpu.Topology = append(gpu.Topology, nvgputypes.TopologyInfo{BusID: busID(j), Link: link})
+			}
+		}
+		info.Gpus = append(info.Gpus, gpu)
+	}
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	// policy would split GPUs by PCIe switch
+	nvidiaManager.GroupingPolicy = &GroupingPolicy{Levels: [][]int32{{6, 5}, {7, 6, 5, 4, 3, 2, 1}}}
+	nodeInfo := types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	if !nvidiaManager.FullyConnected() {
+		t.Errorf("Expected fully connected system")
+	}
+	for i := 0; i < 8; i++ {
+		res := types.ResourceName(types.DeviceGroupPrefix + "/gpugrp1/nvswitch/gpugrp0/nvswitch/gpu/" + info.Gpus[i].ID + "/cards")
+		if nodeInfo.Allocatable[res] != 1 {
+			t.Errorf("GPU %v not in flat group, have %v", i, nodeInfo.Allocatable)
+		}
+	}
+
+	// a GPU without links to the switches breaks all-to-all connectivity
+	fake := nvidiaManager.np.(*NvidiaFakePlugin)
+	fake.gInfo.Gpus[0].NVSwitchLinks = 0
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+	if nvidiaManager.FullyConnected() {
+		t.Errorf("Expected system not to be fully connected")
+	}
+
+	// direct NVLinks between all GPUs without switches are grouped by the policy
+	for i := range fake.gInfo.Gpus {
+		fake.gInfo.Gpus[i].NVSwitchLinks = 0
+		fake.gInfo.Gpus[i].NVLinks = nil
+		for j := 0; j < 8; j++ {
+			if i != j {
+				fake.gInfo.Gpus[i].NVLinks = append(fake.gInfo.Gpus[i].NVLinks, nvgputypes.NVLinkInfo{BusID: busID(j), Links: 1})
+			}
+		}
+	}
+	nodeInfo = types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	if nvidiaManager.FullyConnected() {
+		t.Errorf("Expected NVLink mesh not to be fully connected")
+	}
+	for res := range nodeInfo.Allocatable {
+		if strings.Contains(string(res), fullyConnectedGroupID) {
+			t.Errorf("Unexpected flat group %v", res)
+		}
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	var info nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info)
+	if info.Gpus[0].Power != 250 || info.Gpus[0].Clocks.Cores != 1392 || info.Gpus[0].Family != "Maxwell" ||
+		info.Gpus[4].CPUAffinity == nil || *info.Gpus[4].CPUAffinity != 1 {
+		t.Fatalf("Attributes not parsed %+v", info.Gpus[4])
+	}
+	if computeCapability("5.2") != 52 || computeCapability("") != 0 || computeCapability("x.1") != 0 {
+		t.Errorf("Unexpected compute capability encoding")
+	}
+
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	if _, err := ParseAttributes("power,sm-count"); err == nil {
+		t.Errorf("Expected error for unknown attribute")
+	}
+	attrs, err := ParseAttributes("power, clocks-memory,cores,memory-bandwidth,compute-capability")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	ngm.(*NvidiaGPUManager).AdvertisedAttributes = attrs
+	nodeInfo := types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	capExpected := make(map[string]int64)
+	capExpected[string(gputypes.ResourceGPU)] = int64(len(info.Gpus))
+	addVersionResources(capExpected, &info)
+	for i := 0; i < len(info.Gpus); i++ {
+		prefix := string(types.DeviceGroupPrefix) + groupPrefix(&info, (i/4)*4, (i/2)*2) + "/gpu/" + info.Gpus[i].ID
+		capExpected[prefix+"/cards"] = 1
+		capExpected[prefix+"/memory"] = info.Gpus[i].Memory.Global * int64(1024) * int64(1024)
+		capExpected[prefix+"/power"] = 250
+		capExpected[prefix+"/clocks-memory"] = 3505
+		capExpected[prefix+"/cores"] = 3072
+		capExpected[prefix+"/memory-bandwidth"] = 336480 * int64(1000) * int64(1000)
+		capExpected[prefix+"/compute-capability"] = 52
+	}
+	assertMapEqual(t, nodeInfo.Capacity, capExpected)
+	assertMapEqual(t, nodeInfo.Allocatable, allocatableExpected(capExpected))
+}
+
+func TestNUMA(t *testing.T) {
+	// eight GPUs in pairs behind PCIe switches, four per NUMA node, all peers listed as NVML does
+	info := nvgputypes.GpusInfo{Version: nvgputypes.VersionInfo{Driver: "418.87.01"}}
+	for i := 0; i < 8; i++ {
+		node := int64(i / 4)
+		gpu := nvgputypes.GpuInfo{
+			ID:       "GPU0" + strconv.Itoa(i),
+			Path:     "/dev/nvidia" + strconv.Itoa(i),
+			Memory:   nvgputypes.MemoryInfo{Global: 16160},
+			PCI:      nvgputypes.PciInfo{BusID: "0000:0" + strconv.Itoa(i) + ":00.0"},
+			NUMANode: &node,
+			CPUSet:   []string{"0-7", "8-15"}[node],
+		}
+		for j := 0; j < 8; j++ {
+			link := int32(1)
+			if i/2 == j/2 {
+				link = 5
+			} else if i/4 == j/4 {
+				link = 2
+			}
+			if i != j {
+				gpu.Topology = append(gpu.Topology, nvgputypes.TopologyInfo{BusID: "0000:0" + strconv.Itoa(j) + ":00.0", Link: link})
+			}
+		}
+		info.Gpus = append(info.Gpus, gpu)
+	}
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	ngm.(*NvidiaGPUManager).CPUAffinityHints = true
+	nodeInfo := types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	capExpected := make(map[string]int64)
+	capExpected[string(gputypes.ResourceGPU)] = int64(len(info.Gpus))
+	addVersionResources(capExpected, &info)
+	for i := 0; i < len(info.Gpus); i++ {
+		prefix := groupPrefix(&info, (i/4)*4, (i/2)*2)
+		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/cards"] = 1
+		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/memory"] = info.Gpus[i].Memory.Global * int64(1024) * int64(1024)
+	}
+	assertMapEqual(t, nodeInfo.Capacity, capExpected)
+
+	allocate := func(podName string, alloc map[int]int) map[string]string {
+		container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+		for from, to := range alloc {
+			setAllocFrom(&info, container.AllocateFrom, from, to)
+		}
+		pod := &types.PodInfo{Name: podName, RunningContainers: map[string]types.ContainerInfo{"main": container}}
+		_, _, env, err := ngm.Allocate(pod, &container)
+		if err != nil {
+			t.Errorf("Got error %v", err)
+		}
+		return env
+	}
+	env := allocate("A", map[int]int{0: 0, 1: 2})
+	if env[envCPUSet] != "0-7" || env[envNUMANode] != "0" {
+		t.Errorf("Unexpected CPU hints %v", env)
+	}
+	env = allocate("B", map[int]int{0: 3, 1: 4})
+	if _, ok := env[envNUMANode]; env[envCPUSet] != "0-15" || ok {
+		t.Errorf("Unexpected CPU hints %v", env)
+	}
+
+	// the CPU socket reported by the nvidia docker plugin is suggested as a socket, not as a NUMA node
+	var socketInfo nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &socketInfo)
+	ngm, _ = NewFakeNvidiaGPUManager(&socketInfo, volumeName, volumeDriver)
+	ngm.(*NvidiaGPUManager).CPUAffinityHints = true
+	ngm.UpdateNodeInfo(types.NewNodeInfo())
+	container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+	setAllocFrom(&socketInfo, container.AllocateFrom, 0, 4)
+	_, _, env, err := ngm.Allocate(&types.PodInfo{Name: "C", RunningContainers: map[string]types.ContainerInfo{"main": container}}, &container)
+	if _, ok := env[envNUMANode]; err != nil || env[envCPUSocket] != "1" || ok {
+		t.Errorf("Unexpected CPU hints %v %v", env, err)
+	}
+
+	cpus, err := parseCPUList("0-2,8,10-11")
+	if err != nil || formatCPUList(cpus) != "0-2,8,10-11" {
+		t.Errorf("Unexpected cpu list %v %v", cpus, err)
+	}
+	if _, err := parseCPUList("3-1"); err == nil {
+		t.Errorf("Expected error for invalid cpu list")
+	}
+}
+
+func TestRDMANICs(t *testing.T) {
+	sysfs := jsonStringSysfs(t)
+	defer os.RemoveAll(sysfs)
+	addNIC := func(name string, pciPath string, numaNode string, verbs string) {
+		dir := addPCIDevice(sysfs, pciPath, numaNode)
+		os.MkdirAll(filepath.Join(dir, "infiniband_verbs", verbs), 0755)
+		os.MkdirAll(filepath.Join(sysfs, "class", "infiniband", name), 0755)
+		os.Symlink(dir, filepath.Join(sysfs, "class", "infiniband", name, "device"))
+	}
+	// NIC on the switch of GPU0 and GPU1, NIC on its own root port, NIC on another root complex of socket 1
+	addNIC("mlx5_0", "pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:02.0/0000:06:00.0", "0", "uverbs0")
+	addNIC("mlx5_1", "pci0000:00/0000:00:03.0/0000:0a:00.0", "0", "uverbs1")
+	addNIC("mlx5_2", "pci0000:c0/0000:c0:01.0/0000:c1:00.0", "1", "uverbs2")
+
+	var info nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info)
+	ngm, _ := NewFakeNvidiaGPUManager(&info, volumeName, volumeDriver)
+	nvidiaManager := ngm.(*NvidiaGPUManager)
+	nvidiaManager.DiscoverRDMANICs = true
+	nvidiaManager.SysfsRoot = sysfs
+	nvidiaManager.NCCLHints = true
+	nvidiaManager.ManifestDir = filepath.Join(sysfs, "manifests")
+	nodeInfo := types.NewNodeInfo()
+	ngm.UpdateNodeInfo(nodeInfo)
+	capExpected := make(map[string]int64)
+	capExpected[string(gputypes.ResourceGPU)] = int64(len(info.Gpus))
+	addVersionResources(capExpected, &info)
+	for i := 0; i < len(info.Gpus); i++ {
+		prefix := jsonStringSysfsPrefix(i)
+		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/cards"] = 1
+		capExpected[string(types.DeviceGroupPrefix)+prefix+"/gpu/"+info.Gpus[i].ID+"/memory"] = info.Gpus[i].Memory.Global * int64(1024) * int64(1024)
+	}
+	nic0 := string(types.DeviceGroupPrefix) + jsonStringSysfsPrefix(0) + "/nic/mlx5_0/count"
+	capExpected[nic0] = 1
+	// NICs without a GPU below their switch join the group of the nearest GPU, so that they can be allocated
+	capExpected[string(types.DeviceGroupPrefix)+jsonStringSysfsPrefix(0)+"/nic/mlx5_1/count"] = 1
+	capExpected[string(types.DeviceGroupPrefix)+jsonStringSysfsPrefix(4)+"/nic/mlx5_2/count"] = 1
+	assertMapEqual(t, nodeInfo.Capacity, capExpected)
+	assertMapEqual(t, nodeInfo.Allocatable, allocatableExpected(capExpected))
+
+	container := types.ContainerInfo{AllocateFrom: make(types.ResourceLocation)}
+	setAllocFrom(&info, container.AllocateFrom, 0, 0)
+	setAllocFrom(&info, container.AllocateFrom, 1, 1)
+	container.AllocateFrom[types.ResourceName(string(types.DeviceGroupPrefix)+"/gpugrp1/0/gpugrp0/0/nic/0/count")] = types.ResourceName(nic0)
+	pod := &types.PodInfo{Name: "A", RunningContainers: map[string]types.ContainerInfo{"main": container}}
+	mounts, devices, env, err := ngm.Allocate(pod, &container)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	checkElemEqual(t, devices, []string{"/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm"})
+	if env["NCCL_IB_HCA"] != "mlx5_0" || env["NVIDIA_VISIBLE_DEVICES"] != "GPU00,GPU01" {
+		t.Errorf("Unexpected env %v", env)
+	}
+	// the GPUs and the NIC are below the same PCIe switch in the NCCL topology
+	body, _ := ioutil.ReadFile(mounts[len(mounts)-1].HostPath)
+	var system ncclTopoSystem
+	xml.Unmarshal(body, &system)
+	if len(system.CPUs) != 1 || len(system.CPUs[0].PCIs) != 1 || system.CPUs[0].PCIs[0].BusID != "0000:01:00.0" ||
+		len(system.CPUs[0].PCIs[0].PCIs) != 3 || system.CPUs[0].PCIs[0].PCIs[2].NIC.Nets[0].Name != "mlx5_0" {
+		t.Errorf("Unexpected NCCL topology %s", body)
+	}
+
+	container.AllocateFrom[types.ResourceName(string(types.DeviceGroupPrefix)+"/gpugrp1/0/gpugrp0/0/nic/0/count")] = types.ResourceName(string(types.DeviceGroupPrefix) + jsonStringSysfsPrefix(0) + "/nic/mlx5_9/count")
+	pod = &types.PodInfo{Name: "A", RunningContainers: map[string]types.ContainerInfo{"main": container}}
+	if _, _, _, err := ngm.Allocate(pod, &container); err == nil || !strings.Contains(err.Error(), "mlx5_9") {
+		t.Errorf("Expected error for unknown NIC, have %v", err)
+	}
+
+	// in CDI mode the NICs are described by their own CDI spec
+	nvidiaManager.AllocationMode = AllocationModeCDI
+	nvidiaManager.CDISpecDir = filepath.Join(sysfs, "cdi")
+	nvidiaManager.updateGPUInfo(true)
+	var spec cdiSpec
+	body, _ = ioutil.ReadFile(filepath.Join(sysfs, "cdi", cdiNICSpecFileName))
+	json.Unmarshal(body, &spec)
+	if spec.Kind != cdiNICKind || len(spec.Devices) != 3 || spec.Devices[0].Name != "mlx5_0" ||
+		spec.Devices[0].ContainerEdits.DeviceNodes[0].Path != "/dev/infiniband/uverbs0" ||
+		spec.ContainerEdits.DeviceNodes[0].Path != "/dev/infiniband/rdma_cm" {
+		t.Errorf("Unexpected NIC CDI spec %s", body)
+	}
+	container.AllocateFrom[types.ResourceName(string(types.DeviceGroupPrefix)+"/gpugrp1/0/gpugrp0/0/nic/0/count")] = types.ResourceName(nic0)
+	_, devices, _, err = nvidiaManager.AllocateContainer("A", "main", &container)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	checkElemEqual(t, devices, []string{"kubegpu.microsoft.com/gpu=GPU00", "kubegpu.microsoft.com/gpu=GPU01", "kubegpu.microsoft.com/rdma=mlx5_0"})
+}
+
+func TestFixtures(t *testing.T) {
+	// number of GPUs in each gpugrp1/gpugrp0 group of the designs
+	expected := map[string]map[string]int{
+		"pcie-dual-socket": {
+			"gpugrp1/numa0/gpugrp0/0000_04_00_0": 2, "gpugrp1/numa0/gpugrp0/0000_08_00_0": 2,
+			"gpugrp1/numa1/gpugrp0/0000_84_00_0": 2, "gpugrp1/numa1/gpugrp0/0000_88_00_0": 2,
+		},
+		// the NVLinks between the sockets, e.g. GPU0 to GPU4, do not pull GPUs into a gpugrp0 on the other NUMA node
+		"dgx1": {
+			"gpugrp1/numa0/gpugrp0/0000_04_00_0": 4, "gpugrp1/numa1/gpugrp0/0000_84_00_0": 4,
+		},
+		"dgx2":          {"gpugrp1/nvswitch/gpugrp0/nvswitch": 16},
+		"single-switch": {"gpugrp1/numa0/gpugrp0/0000_1a_00_0": 4},
+		"cloud-vm": {
+			"gpugrp1/1a5e_00_00_0/gpugrp0/1a5e_00_00_0": 1, "gpugrp1/2a5e_00_00_0/gpugrp0/2a5e_00_00_0": 1,
+			"gpugrp1/3a5e_00_00_0/gpugrp0/3a5e_00_00_0": 1, "gpugrp1/4a5e_00_00_0/gpugrp0/4a5e_00_00_0": 1,
+		},
+		// MIG partitioned GPUs are allocated as whole GPUs
+		"mig-a100": {"gpugrp1/numa0/gpugrp0/0000_07_00_0": 1},
+	}
+	for design := range fixtures.Designs {
+		info, err := fixtures.Generate(design, fixtures.Options{})
+		if err != nil {
+			t.Fatalf("Generating %v fails: %v", design, err)
+		}
+		ngm, _ := NewFakeNvidiaGPUManager(info, volumeName, volumeDriver)
+		nodeInfo := types.NewNodeInfo()
+		ngm.UpdateNodeInfo(nodeInfo)
+		groups := make(map[string]int)
+		for _, gpu := range info.Gpus {
+			for res := range nodeInfo.Allocatable {
+				if strings.HasSuffix(string(res), "/gpu/"+gpu.ID+"/cards") {
+					groups[strings.TrimSuffix(strings.TrimPrefix(string(res), types.DeviceGroupPrefix+"/"), "/gpu/"+gpu.ID+"/cards")]++
+				}
+			}
+		}
+		if !reflect.DeepEqual(groups, expected[design]) {
+			t.Errorf("Unexpected groups of %v, expected %v, have %v", design, expected[design], groups)
+		}
+	}
+
+	info, _ := fixtures.Generate("mig-a100", fixtures.Options{GPUs: 4, MIGProfile: "3g.20gb"})
+	if len(info.Gpus) != 4 || info.Gpus[1].Memory.Global != 40536 || info.Gpus[1].MIG == nil ||
+		*info.Gpus[1].MIG != (nvgputypes.MIGInfo{Profile: "3g.20gb", Instances: 2, Memory: 20096, Cores: 2688}) {
+		t.Errorf("Unexpected MIG GPUs %+v", info.Gpus)
+	}
+	info, _ = fixtures.Generate("pcie-dual-socket", fixtures.Options{GPUs: 4, Memory: 8192, Model: "Tesla P4"})
+	if len(info.Gpus) != 4 || info.Gpus[3].Memory.Global != 8192 || info.Gpus[3].Model != "Tesla P4" || *info.Gpus[3].NUMANode != 1 {
+		t.Errorf("Unexpected GPUs %+v", info.Gpus)
+	}
+	if _, err := fixtures.Generate("dgx1", fixtures.Options{GPUs: 6}); err == nil {
+		t.Errorf("Expected error generating DGX-1 with 6 GPUs")
+	}
+	if _, err := fixtures.Generate("mig-a100", fixtures.Options{MIGProfile: "5g.30gb"}); err == nil {
+		t.Errorf("Expected error for unknown MIG profile")
+	}
+	if _, err := fixtures.Generate("dgx3", fixtures.Options{}); err == nil {
+		t.Errorf("Expected error for unknown design")
+	}
+}
+
+func TestNVMLUnits(t *testing.T) {
+	var info nvgputypes.GpusInfo
+	json.Unmarshal([]byte(jsonString), &info)
+	if InNVMLUnits(&info) {
+		t.Errorf("Inventory in MiB detected as NVML units")
+	}
+	memory := info.Gpus[0].Memory.Global
+	// as printed by nvmlinfo json
+	for i := range info.Gpus {
+		info.Gpus[i].Memory.Global *= int64(1024) * int64(1024)
+		info.Gpus[i].PCI.Bandwidth *= int64(1000) * int64(1000)
+	}
+	if !InNVMLUnits(&info) {
+		t.Fatalf("Inventory in bytes not detected as NVML units")
+	}
+	ToPluginUnits(&info)
+	if info.Gpus[0].Memory.Global != memory || InNVMLUnits(&info) {
+		t.Errorf("Expected %v MiB, have %v", memory, info.Gpus[0].Memory.Global)
+	}
+}
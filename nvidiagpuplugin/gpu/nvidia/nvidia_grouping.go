@@ -0,0 +1,68 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+// GroupingPolicy decides which links put GPUs in the same topology group
+// Levels[0] lists the link types forming gpugrp0, Levels[1] the link types forming gpugrp1
+type GroupingPolicy struct {
+	Levels [][]int32 `json:"Levels"`
+}
+
+// grouping policy used by the plugin, if present
+const defaultGroupingPolicyPath = "/etc/kubegpu/grouping.json"
+
+// number of group levels, the scheduler and Allocate expect gpugrp1/<id>/gpugrp0/<id>
+const numGroupLevels = 2
+
+// maximum link level, see nvgputypes.LinkNVLink
+const maxLinkLevel = nvgputypes.LinkNVLink
+
+// DefaultGroupingPolicy puts NVLink peers and GPUs under PCIe switches in gpugrp0, and all linked GPUs in gpugrp1
+func DefaultGroupingPolicy() *GroupingPolicy {
+	return &GroupingPolicy{Levels: [][]int32{{7, 6, 5, 4}, {7, 6, 5, 4, 3, 2, 1}}}
+}
+
+// Validate checks that there are two levels with valid links and that each level contains the links of the level below
+func (gp *GroupingPolicy) Validate() error {
+	if len(gp.Levels) != numGroupLevels {
+		return fmt.Errorf("grouping policy must have exactly %d levels, for the gpugrp0 and gpugrp1 groups the scheduler expects, have %d",
+			numGroupLevels, len(gp.Levels))
+	}
+	for level, links := range gp.Levels {
+		for _, link := range links {
+			if link < 0 || link > maxLinkLevel {
+				return fmt.Errorf("grouping policy level %d has invalid link type %d", level, link)
+			}
+		}
+		if level > 0 {
+			for _, link := range gp.Levels[level-1] {
+				if !arrayContains(links, link) {
+					return fmt.Errorf("grouping policy levels do not nest, link type %d of level %d missing in level %d", link, level-1, level)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// LoadGroupingPolicy reads a grouping policy from a JSON file such as {"Levels": [[7], [7, 6, 5, 4, 3]]}
+func LoadGroupingPolicy(path string) (*GroupingPolicy, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	gp := &GroupingPolicy{}
+	if err := json.Unmarshal(body, gp); err != nil {
+		return nil, err
+	}
+	if err := gp.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return gp, nil
+}
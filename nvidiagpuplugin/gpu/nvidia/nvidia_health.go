@@ -0,0 +1,197 @@
+package nvidia
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+const (
+	defaultHealthRecoveryPeriod = 10 * time.Minute
+	// DefaultRetiredPagesThreshold is the number of retired pages at which a device is considered unhealthy by default
+	// NVIDIA considers a device for replacement at 60 pages retired due to ECC errors
+	DefaultRetiredPagesThreshold    = 60
+	defaultRetiredPagesPollInterval = time.Minute
+	healthWaitTimeout               = 5 * time.Second
+)
+
+// XIDs which are caused by the application rather than the device, these do not mark a device unhealthy
+// See https://docs.nvidia.com/deploy/xid-errors/index.html
+var applicationXids = map[uint64]bool{
+	13: true, // graphics engine exception
+	31: true, // GPU memory page fault
+	43: true, // GPU stopped processing
+	45: true, // preemptive cleanup, due to previous errors
+	68: true, // video processor exception
+}
+
+// StartHealthWatch starts a background watcher on the health event source, if any
+func (ngm *NvidiaGPUManager) StartHealthWatch() {
+	ngm.Lock()
+	defer ngm.Unlock()
+	if (ngm.healthSource == nil && ngm.retiredPagesSource == nil) || ngm.healthStop != nil {
+		return
+	}
+	ngm.healthStop = make(chan struct{})
+	go ngm.watchHealth(ngm.healthSource, ngm.retiredPagesSource, ngm.healthStop)
+}
+
+// StopHealthWatch stops the background watcher and closes the event source
+func (ngm *NvidiaGPUManager) StopHealthWatch() {
+	ngm.Lock()
+	defer ngm.Unlock()
+	if ngm.healthStop != nil {
+		close(ngm.healthStop)
+		ngm.healthStop = nil
+	}
+}
+
+func (ngm *NvidiaGPUManager) unregisteredGPUs(registered map[string]bool) []string {
+	ngm.Lock()
+	defer ngm.Unlock()
+	ids := []string{}
+	for _, id := range ngm.indexToID {
+		if !registered[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// watchHealth waits for events of source and polls the retired pages of retiredPages, either may be nil
+func (ngm *NvidiaGPUManager) watchHealth(source nvgputypes.HealthEventSource, retiredPages nvgputypes.RetiredPagesSource, stop chan struct{}) {
+	if source != nil {
+		defer source.Close()
+	}
+	registered := make(map[string]bool)
+	var lastPoll time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if retiredPages != nil && time.Now().Sub(lastPoll) >= ngm.RetiredPagesPollInterval {
+			ngm.pollRetiredPages(retiredPages, time.Now())
+			lastPoll = time.Now()
+		}
+		if source == nil {
+			select {
+			case <-stop:
+				return
+			case <-time.After(healthWaitTimeout):
+			}
+			ngm.recoverDevices(time.Now())
+			continue
+		}
+		// register newly discovered devices
+		newIDs := ngm.unregisteredGPUs(registered)
+		if len(newIDs) > 0 {
+			if err := source.Register(newIDs); err != nil {
+				utils.Errorf("Registering GPUs %v for health events fails: %v", newIDs, err)
+			}
+			for _, id := range newIDs {
+				registered[id] = true
+			}
+		}
+		event, err := source.Wait(healthWaitTimeout)
+		if err != nil {
+			utils.Errorf("Waiting for GPU health events fails: %v", err)
+			// do not spin on a broken event set
+			select {
+			case <-stop:
+				return
+			case <-time.After(healthWaitTimeout):
+			}
+		} else if event != nil {
+			ngm.handleHealthEvent(event, time.Now())
+		}
+		ngm.recoverDevices(time.Now())
+	}
+}
+
+// pollRetiredPages turns the page retirement status of the devices into health events
+func (ngm *NvidiaGPUManager) pollRetiredPages(source nvgputypes.RetiredPagesSource, now time.Time) {
+	pages, err := source.RetiredPages()
+	if err != nil {
+		utils.Errorf("Polling retired pages fails: %v", err)
+		return
+	}
+	for _, uuid := range utils.SortedStringKeys(pages) {
+		status := pages[uuid]
+		if status.Count > 0 {
+			ngm.handleHealthEvent(&nvgputypes.HealthEvent{UUID: uuid, Type: nvgputypes.HealthEventRetiredPages, Data: status.Count}, now)
+		}
+		if status.Pending {
+			ngm.handleHealthEvent(&nvgputypes.HealthEvent{UUID: uuid, Type: nvgputypes.HealthEventRetirementPending}, now)
+		}
+	}
+}
+
+func (ngm *NvidiaGPUManager) healthEventReason(event *nvgputypes.HealthEvent) string {
+	return HealthEventReason(event, ngm.RetiredPagesThreshold)
+}
+
+// HealthEventReason returns why the event makes a device unhealthy, or "" if the event is not critical
+func HealthEventReason(event *nvgputypes.HealthEvent, retiredPagesThreshold uint64) string {
+	switch event.Type {
+	case nvgputypes.HealthEventXid:
+		if applicationXids[event.Data] {
+			return ""
+		}
+		return "XID " + strconv.FormatUint(event.Data, 10)
+	case nvgputypes.HealthEventDoubleBitECC:
+		return "double bit ECC error"
+	case nvgputypes.HealthEventRetiredPages:
+		if event.Data < retiredPagesThreshold {
+			return ""
+		}
+		return strconv.FormatUint(event.Data, 10) + " retired pages"
+	case nvgputypes.HealthEventRetirementPending:
+		return "page retirement pending, device needs a reset"
+	}
+	return ""
+}
+
+// handleHealthEvent marks the device unhealthy if the event is critical
+func (ngm *NvidiaGPUManager) handleHealthEvent(event *nvgputypes.HealthEvent, now time.Time) {
+	ngm.Lock()
+	defer ngm.Unlock()
+	gpu, available := ngm.gpus[event.UUID]
+	if !available {
+		utils.Logf(3, "Health event %+v for unknown GPU", event)
+		return
+	}
+	reason := ngm.healthEventReason(event)
+	if reason == "" {
+		utils.Logf(4, "Ignoring non-critical health event %+v", event)
+		return
+	}
+	utils.Logf(0, "GPU %v marked unhealthy: %v", event.UUID, reason)
+	wasUnhealthy := gpu.Unhealthy
+	gpu.Unhealthy = true
+	gpu.HealthReason = reason
+	gpu.UnhealthySince = now
+	ngm.gpus[event.UUID] = gpu
+	if !wasUnhealthy {
+		ngm.publish(InventoryEvent{Type: GPUHealthChanged, ID: event.UUID, GPU: gpu, New: reason})
+	}
+}
+
+// recoverDevices marks devices healthy again once no critical event is seen for HealthRecoveryPeriod
+func (ngm *NvidiaGPUManager) recoverDevices(now time.Time) {
+	ngm.Lock()
+	defer ngm.Unlock()
+	for id, gpu := range ngm.gpus {
+		if gpu.Unhealthy && now.Sub(gpu.UnhealthySince) >= ngm.HealthRecoveryPeriod {
+			utils.Logf(0, "GPU %v recovered after %v", id, now.Sub(gpu.UnhealthySince))
+			reason := gpu.HealthReason
+			gpu.Unhealthy = false
+			gpu.HealthReason = ""
+			ngm.gpus[id] = gpu
+			ngm.publish(InventoryEvent{Type: GPUHealthChanged, ID: id, GPU: gpu, Old: reason})
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	devtypes "github.com/Microsoft/KubeDevice-API/pkg/device"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+const defaultManifestContainerPath = "/etc/kubegpu/allocation.json"
+
+// manifest describes the GPUs in the given order, must be called with lock held
+func (ngm *NvidiaGPUManager) manifest(podName string, contName string, ids []string) *nvgputypes.AllocationManifest {
+	manifest := &nvgputypes.AllocationManifest{Pod: podName, Container: contName}
+	for _, id := range ids {
+		gpu := ngm.gpus[id]
+		manifest.Gpus = append(manifest.Gpus, nvgputypes.ManifestGpu{
+			UUID:   id,
+			BusID:  gpu.PCI.BusID,
+			Model:  gpu.Model,
+			Memory: gpu.Memory.Global,
+			Group:  path.Dir(path.Dir(gpu.Name)),
+		})
+	}
+	for _, idI := range ids {
+		for _, idJ := range ids {
+			if idI == idJ {
+				continue
+			}
+			if link := ngm.linkBetween(idI, idJ); link >= 0 {
+				manifest.Links = append(manifest.Links, nvgputypes.ManifestLink{From: idI, To: idJ, Link: link})
+			}
+		}
+	}
+	return manifest
+}
+
+// podDir returns the directory of the per-container files of a pod below root
+func podDir(root string, podName string) string {
+	return filepath.Join(root, strings.Replace(podName, "/", "_", -1))
+}
+
+// containerFile returns the host path of a per-container file with the given extension in the pod directory below root
+func containerFile(root string, podName string, contName string, ext string) string {
+	return filepath.Join(podDir(root, podName), strings.Replace(contName, "/", "_", -1)+ext)
+}
+
+// writeManifest writes the manifest for the container and returns a mount for it, must be called with lock held
+func (ngm *NvidiaGPUManager) writeManifest(podName string, contName string, ids []string) (*devtypes.Mount, error) {
+	body, err := json.MarshalIndent(ngm.manifest(podName, contName, ids), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(podDir(ngm.ManifestDir, podName), 0755); err != nil {
+		return nil, err
+	}
+	hostPath := containerFile(ngm.ManifestDir, podName, contName, ".json")
+	if err := ioutil.WriteFile(hostPath, body, 0644); err != nil {
+		return nil, err
+	}
+	return &devtypes.Mount{HostPath: hostPath, ContainerPath: ngm.ManifestContainerPath, Readonly: true}, nil
+}
+
+// removeManifests removes the manifests and NCCL topology files of a pod, must be called with lock held
+func (ngm *NvidiaGPUManager) removeManifests(podName string) {
+	if ngm.ManifestDir != "" {
+		os.RemoveAll(podDir(ngm.ManifestDir, podName))
+	}
+	if ngm.NCCLHints {
+		os.RemoveAll(podDir(ngm.ncclTopoDir(), podName))
+	}
+}
+
+// removeContainerManifests removes the manifest and NCCL topology file of a container, must be called with lock held
+func (ngm *NvidiaGPUManager) removeContainerManifests(podName string, contName string) {
+	if ngm.ManifestDir != "" {
+		os.Remove(containerFile(ngm.ManifestDir, podName, contName, ".json"))
+	}
+	if ngm.NCCLHints {
+		os.Remove(containerFile(ngm.ncclTopoDir(), podName, contName, ".nccl.xml"))
+	}
+}
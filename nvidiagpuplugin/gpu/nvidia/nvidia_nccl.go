@@ -0,0 +1,163 @@
+package nvidia
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	devtypes "github.com/Microsoft/KubeDevice-API/pkg/device"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+const defaultNCCLTopoContainerPath = "/etc/kubegpu/nccl-topo.xml"
+
+// host directory NCCL topology files are written to if neither NCCLTopoDir nor ManifestDir is set
+const defaultNCCLTopoDir = "/var/lib/kubegpu/nccl"
+
+// PCI classes as used in NCCL topology files, NCCL matches GPUs on the 0x03 and NICs on the 0x02 prefix
+const (
+	ncclClassBridge = "0x060400"
+	ncclClassGPU    = "0x030200"
+	ncclClassNIC    = "0x020700"
+)
+
+// NCCL topology file, see NCCL_TOPO_FILE, attributes left out are filled in by NCCL from sysfs and NVML
+type ncclTopoSystem struct {
+	XMLName xml.Name      `xml:"system"`
+	Version int           `xml:"version,attr"`
+	CPUs    []ncclTopoCPU `xml:"cpu"`
+}
+
+type ncclTopoCPU struct {
+	NUMAID int64          `xml:"numaid,attr"`
+	PCIs   []*ncclTopoPCI `xml:"pci"`
+}
+
+type ncclTopoPCI struct {
+	BusID string         `xml:"busid,attr"`
+	Class string         `xml:"class,attr"`
+	PCIs  []*ncclTopoPCI `xml:"pci"`
+	GPU   *ncclTopoGPU   `xml:"gpu"`
+	NIC   *ncclTopoNIC   `xml:"nic"`
+}
+
+type ncclTopoGPU struct {
+	Dev     int              `xml:"dev,attr"`
+	SM      string           `xml:"sm,attr,omitempty"`
+	NVLinks []ncclTopoNVLink `xml:"nvlink"`
+}
+
+type ncclTopoNVLink struct {
+	Target string `xml:"target,attr"`
+	Count  int32  `xml:"count,attr"`
+	TClass string `xml:"tclass,attr"`
+}
+
+type ncclTopoNIC struct {
+	Nets []ncclTopoNet `xml:"net"`
+}
+
+type ncclTopoNet struct {
+	Name string `xml:"name,attr"`
+	Dev  int    `xml:"dev,attr"`
+}
+
+// ncclTopoBuilder places devices below their NUMA node and PCIe switch
+type ncclTopoBuilder struct {
+	cpus     map[int64]*ncclTopoCPU
+	switches map[string]*ncclTopoPCI
+}
+
+func (b *ncclTopoBuilder) add(numaNode int64, pciPath string, device *ncclTopoPCI) {
+	cpu, found := b.cpus[numaNode]
+	if !found {
+		cpu = &ncclTopoCPU{NUMAID: numaNode}
+		b.cpus[numaNode] = cpu
+	}
+	parts := strings.Split(pciPath, "/")
+	if len(parts) < 3 {
+		cpu.PCIs = append(cpu.PCIs, device)
+		return
+	}
+	busID := switchBusID(parts)
+	bridge, found := b.switches[busID]
+	if !found {
+		bridge = &ncclTopoPCI{BusID: busID, Class: ncclClassBridge}
+		b.switches[busID] = bridge
+		cpu.PCIs = append(cpu.PCIs, bridge)
+	}
+	bridge.PCIs = append(bridge.PCIs, device)
+}
+
+// ncclTopology describes the GPUs, in the order they are visible in the container, and the NICs for NCCL
+// must be called with lock held
+func (ngm *NvidiaGPUManager) ncclTopology(ids []string, nicNames []string) *ncclTopoSystem {
+	builder := &ncclTopoBuilder{cpus: make(map[int64]*ncclTopoCPU), switches: make(map[string]*ncclTopoPCI)}
+	pciPaths := ngm.gpuPCIPaths()
+	for dev, id := range ids {
+		gpu := ngm.gpus[id]
+		topoGPU := &ncclTopoGPU{Dev: dev, SM: strings.Replace(gpu.Arch, ".", "", -1)}
+		for _, nvlink := range gpu.NVLinks {
+			topoGPU.NVLinks = append(topoGPU.NVLinks, ncclTopoNVLink{
+				Target: nvgputypes.NormalizeBusID(nvlink.BusID),
+				Count:  nvlink.Links,
+				TClass: ncclClassGPU,
+			})
+		}
+		builder.add(ngm.numaNode(id), pciPaths[id], &ncclTopoPCI{
+			BusID: nvgputypes.NormalizeBusID(gpu.PCI.BusID),
+			Class: ncclClassGPU,
+			GPU:   topoGPU,
+		})
+	}
+	for dev, name := range nicNames {
+		nic := ngm.nics[name]
+		builder.add(nic.NUMANode, nic.PCIPath, &ncclTopoPCI{
+			BusID: nic.BusID,
+			Class: ncclClassNIC,
+			NIC:   &ncclTopoNIC{Nets: []ncclTopoNet{{Name: name, Dev: dev}}},
+		})
+	}
+	nodes := []int64{}
+	for node := range builder.cpus {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	system := &ncclTopoSystem{Version: 1}
+	for _, node := range nodes {
+		system.CPUs = append(system.CPUs, *builder.cpus[node])
+	}
+	return system
+}
+
+// ncclTopoDir returns the host directory NCCL topology files are written to, NCCLTopoDir, else next to the manifests
+// in ManifestDir, else defaultNCCLTopoDir
+func (ngm *NvidiaGPUManager) ncclTopoDir() string {
+	if ngm.NCCLTopoDir != "" {
+		return ngm.NCCLTopoDir
+	}
+	if ngm.ManifestDir != "" {
+		return ngm.ManifestDir
+	}
+	return defaultNCCLTopoDir
+}
+
+// writeNCCLTopology writes the NCCL topology file for the container to the NCCL topology directory and returns a mount
+// for it, must be called with lock held
+func (ngm *NvidiaGPUManager) writeNCCLTopology(podKey string, contName string, ids []string, nicNames []string) (*devtypes.Mount, error) {
+	body, err := xml.MarshalIndent(ngm.ncclTopology(ids, nicNames), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	dir := ngm.ncclTopoDir()
+	if err := os.MkdirAll(podDir(dir, podKey), 0755); err != nil {
+		return nil, err
+	}
+	hostPath := containerFile(dir, podKey, contName, ".nccl.xml")
+	if err := ioutil.WriteFile(hostPath, body, 0644); err != nil {
+		return nil, err
+	}
+	return &devtypes.Mount{HostPath: hostPath, ContainerPath: ngm.NCCLTopoContainerPath, Readonly: true}, nil
+}
@@ -0,0 +1,178 @@
+package nvidia
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/types"
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+)
+
+// device nodes needed by all containers using RDMA NICs
+var rdmaControlDevices = []string{"/dev/infiniband/rdma_cm"}
+
+var allocateFromNICRE = regexp.MustCompile("^" + types.DeviceGroupPrefix + "/.*/nic/" + `([^/]+)/count$`)
+
+type nicInfo struct {
+	Name     string   // e.g. mlx5_0
+	BusID    string   // PCI bus ID in sysfs form
+	PCIPath  string   // PCI ancestry, e.g. pci0000:00/0000:00:01.0/0000:02:00.0
+	NUMANode int64    // -1 if unknown
+	Devices  []string // verbs device nodes
+	Group    string   // gpugrp1/<id>/gpugrp0/<id>/nic/<name>
+}
+
+// pciPath returns the PCI ancestry of a device below sysfs devices, or "" if the device is not found
+func pciPath(sysfsRoot string, devicePath string) string {
+	resolved, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return ""
+	}
+	devicesDir, err := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "devices"))
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(devicesDir, resolved)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+func readNUMANode(devicePath string) int64 {
+	body, err := ioutil.ReadFile(filepath.Join(devicePath, "numa_node"))
+	if err != nil {
+		return -1
+	}
+	node, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return node
+}
+
+// pciLink returns the link level between two PCI devices given their ancestry, using the GPU link levels
+// devices below a common switch are at level 5, below a common root port at level 4, on one root complex at level 3
+// otherwise the NUMA nodes decide between level 2 and 1
+func pciLink(pathA string, pathB string, nodeA int64, nodeB int64) int32 {
+	partsA := strings.Split(pathA, "/")
+	partsB := strings.Split(pathB, "/")
+	common := 0
+	for common < len(partsA) && common < len(partsB) && partsA[common] == partsB[common] {
+		common++
+	}
+	switch {
+	case common == 0:
+		if nodeA >= 0 && nodeA == nodeB {
+			return 2
+		}
+		return 1
+	case common == 1:
+		return 3
+	case len(partsA)-common <= 2 && len(partsB)-common <= 2:
+		return 5
+	}
+	return 4
+}
+
+// discoverNICs finds the RDMA NICs in sysfs and places each in the group of the nearest GPU
+// must be called with lock held, after topology discovery
+func (ngm *NvidiaGPUManager) discoverNICs() {
+	ngm.nics = make(map[string]nicInfo)
+	classDir := filepath.Join(ngm.SysfsRoot, "class", "infiniband")
+	entries, err := ioutil.ReadDir(classDir)
+	if err != nil {
+		utils.Logf(4, "No RDMA NICs found: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		deviceDir := filepath.Join(classDir, entry.Name(), "device")
+		nic := nicInfo{
+			Name:     entry.Name(),
+			PCIPath:  pciPath(ngm.SysfsRoot, deviceDir),
+			NUMANode: readNUMANode(deviceDir),
+		}
+		if nic.PCIPath == "" {
+			utils.Logf(3, "RDMA NIC %v is not a PCI device, skipping", nic.Name)
+			continue
+		}
+		nic.BusID = path.Base(nic.PCIPath)
+		verbs, _ := ioutil.ReadDir(filepath.Join(deviceDir, "infiniband_verbs"))
+		for _, verb := range verbs {
+			nic.Devices = append(nic.Devices, "/dev/infiniband/"+verb.Name())
+		}
+		nic.Group = ngm.nicGroup(&nic) + "/nic/" + nic.Name
+		utils.Logf(3, "Found RDMA NIC %v at %v in %v", nic.Name, nic.BusID, nic.Group)
+		ngm.nics[nic.Name] = nic
+	}
+}
+
+// nicGroup returns the gpugrp1/<id>/gpugrp0/<id> group of a NIC, must be called with lock held
+// a NIC joins the gpugrp0 group of the nearest GPU, the scheduler requests NICs in the gpugrp0 group of the GPUs of a
+// container, so a NIC in a group without GPUs could never be allocated
+// NICs which are further from the nearest GPU than the grouping policy levels, e.g. on their own root port, are
+// still placed next to it, only NICs on nodes without GPUs are in their own group
+func (ngm *NvidiaGPUManager) nicGroup(nic *nicInfo) string {
+	nearest := ""
+	nearestLink := int32(-1)
+	pciPaths := ngm.gpuPCIPaths()
+	for _, id := range ngm.sortedByBusID() {
+		gpuPath, found := pciPaths[id]
+		if !found {
+			continue
+		}
+		link := pciLink(nic.PCIPath, gpuPath, nic.NUMANode, ngm.numaNode(id))
+		if link > nearestLink {
+			nearest = id
+			nearestLink = link
+		}
+	}
+	if nearest == "" {
+		ownID := groupIDFromBusID(nic.BusID)
+		return "gpugrp1/" + ownID + "/gpugrp0/" + ownID
+	}
+	if !ngm.fullyConnected && !arrayContains(ngm.GroupingPolicy.Levels[0], nearestLink) {
+		utils.Logf(2, "RDMA NIC %v is not below a PCIe switch of a GPU, placed next to GPU %v at link %v", nic.Name, nearest, nearestLink)
+	}
+	// Name is gpugrp1/<id>/gpugrp0/<id>/gpu/<uuid>
+	return path.Dir(path.Dir(ngm.gpus[nearest].Name))
+}
+
+func sortedNICNames(nics map[string]nicInfo) []string {
+	names := []string{}
+	for name := range nics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// nicsFromAllocateFrom returns the names of the NICs the container is allocated, sorted
+func nicsFromAllocateFrom(container *types.ContainerInfo) []string {
+	names := []string{}
+	for _, res := range container.AllocateFrom {
+		matches := allocateFromNICRE.FindStringSubmatch(string(res))
+		if len(matches) >= 2 {
+			names = append(names, matches[1])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// nicDeviceNodes returns the device nodes of the given NICs, must be called with lock held
+func (ngm *NvidiaGPUManager) nicDeviceNodes(names []string) []string {
+	devices := []string{}
+	for _, name := range names {
+		devices = append(devices, ngm.nics[name].Devices...)
+	}
+	if len(devices) > 0 {
+		devices = append(devices, rdmaControlDevices...)
+	}
+	return devices
+}
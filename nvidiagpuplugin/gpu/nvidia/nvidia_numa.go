@@ -0,0 +1,150 @@
+package nvidia
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+// environment variables with the suggested CPU placement for the allocated GPUs
+const (
+	envCPUSet    = "KUBEGPU_CPUSET"
+	envNUMANode  = "KUBEGPU_NUMA_NODE"
+	envCPUSocket = "KUBEGPU_CPU_SOCKET"
+)
+
+// numaNode returns the NUMA node of a GPU, or -1 if unknown, must be called with lock held
+func (ngm *NvidiaGPUManager) numaNode(id string) int64 {
+	gpu := ngm.gpus[id]
+	if gpu.NUMANode != nil && *gpu.NUMANode >= 0 {
+		return *gpu.NUMANode
+	}
+	return -1
+}
+
+// fillNUMANodes reads the NUMA node of the GPUs which do not have one from sysfs, if sysfsRoot is set
+func fillNUMANodes(sysfsRoot string, gpus *nvgputypes.GpusInfo) {
+	if sysfsRoot == "" {
+		return
+	}
+	for i := range gpus.Gpus {
+		if gpus.Gpus[i].NUMANode != nil {
+			continue
+		}
+		devicePath := filepath.Join(sysfsRoot, "bus", "pci", "devices", nvgputypes.NormalizeBusID(gpus.Gpus[i].PCI.BusID))
+		if node := readNUMANode(devicePath); node >= 0 {
+			gpus.Gpus[i].NUMANode = &node
+		}
+	}
+}
+
+// cpuSocket returns the CPU socket of a GPU reported as CPUAffinity, or -1 if unknown, must be called with lock held
+// a socket may hold several NUMA nodes, it is not used as a NUMA node
+func (ngm *NvidiaGPUManager) cpuSocket(id string) int64 {
+	gpu := ngm.gpus[id]
+	if gpu.CPUAffinity != nil && *gpu.CPUAffinity >= 0 {
+		return *gpu.CPUAffinity
+	}
+	return -1
+}
+
+// differentNUMANodes returns true only if both GPUs have a known NUMA node and they differ, or, if the NUMA nodes are
+// not known, a known CPU socket and they differ, GPUs on different sockets are on different NUMA nodes as well
+// must be called with lock held
+func (ngm *NvidiaGPUManager) differentNUMANodes(idI string, idJ string) bool {
+	nodeI := ngm.numaNode(idI)
+	nodeJ := ngm.numaNode(idJ)
+	if nodeI >= 0 && nodeJ >= 0 {
+		return nodeI != nodeJ
+	}
+	socketI := ngm.cpuSocket(idI)
+	socketJ := ngm.cpuSocket(idJ)
+	return socketI >= 0 && socketJ >= 0 && socketI != socketJ
+}
+
+// parseCPUList parses a cpuset list such as "0-3,8,10-11"
+func parseCPUList(list string) ([]int, error) {
+	cpus := []int{}
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return cpus, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %v", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("invalid cpu list %v", list)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// formatCPUList formats cpus in cpuset list format, merging consecutive cpus into ranges
+func formatCPUList(cpus []int) string {
+	sorted := append([]int{}, cpus...)
+	sort.Ints(sorted)
+	parts := []string{}
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, strconv.Itoa(sorted[i])+"-"+strconv.Itoa(sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// cpuHints returns the suggested cpuset and NUMA node for the allocated GPUs, must be called with lock held
+// the cpuset is the union of the local CPUs of all GPUs, the NUMA node is only suggested if all GPUs share it, the
+// CPU socket only if the NUMA node is not known and all GPUs share it
+func (ngm *NvidiaGPUManager) cpuHints(ids []string) map[string]string {
+	env := make(map[string]string)
+	if !ngm.CPUAffinityHints || len(ids) == 0 {
+		return env
+	}
+	cpus := []int{}
+	cpusKnown := true
+	node := ngm.numaNode(ids[0])
+	socket := ngm.cpuSocket(ids[0])
+	for _, id := range ids {
+		if ngm.numaNode(id) != node {
+			node = -1
+		}
+		if ngm.cpuSocket(id) != socket {
+			socket = -1
+		}
+		gpuCPUs, err := parseCPUList(ngm.gpus[id].CPUSet)
+		if err != nil || len(gpuCPUs) == 0 {
+			cpusKnown = false
+			continue
+		}
+		cpus = append(cpus, gpuCPUs...)
+	}
+	if cpusKnown {
+		env[envCPUSet] = formatCPUList(cpus)
+	}
+	if node >= 0 {
+		env[envNUMANode] = strconv.FormatInt(node, 10)
+	} else if socket >= 0 {
+		env[envCPUSocket] = strconv.FormatInt(socket, 10)
+	}
+	return env
+}
@@ -0,0 +1,135 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/utils"
+)
+
+// the read-only API of the kubelet, which lists the pods bound to the node
+const defaultKubeletPodsURL = "http://localhost:10255/pods"
+
+// PodRef identifies a pod on the node
+type PodRef struct {
+	Namespace string
+	Name      string
+	UID       string
+}
+
+// Key returns the key GPUs of the pod are owned by, the UID if known, so that a pod recreated with the same name is
+// a different owner, else <namespace>/<name>
+func (p PodRef) Key() string {
+	if p.UID != "" {
+		return p.UID
+	}
+	return p.Namespace + "/" + p.Name
+}
+
+type kubeletPodList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+			UID       string `json:"uid"`
+		} `json:"metadata"`
+		Status struct {
+			Phase string `json:"phase"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// KubeletLivePods returns a LivePods function listing the pods of the node through the kubelet API at url
+// pods which have terminated, i.e. succeeded or failed, no longer use their GPUs and are not alive
+func KubeletLivePods(url string) func() ([]PodRef, error) {
+	return func() ([]PodRef, error) {
+		body, err := getResponse(url)
+		if err != nil {
+			return nil, err
+		}
+		var list kubeletPodList
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("parsing pods from %v fails: %v", url, err)
+		}
+		pods := []PodRef{}
+		for _, item := range list.Items {
+			if item.Status.Phase == "Succeeded" || item.Status.Phase == "Failed" {
+				continue
+			}
+			pods = append(pods, PodRef{Namespace: item.Metadata.Namespace, Name: item.Metadata.Name, UID: item.Metadata.UID})
+		}
+		return pods, nil
+	}
+}
+
+// livePods returns the pods alive on the node, nil if there is no LivePods source or it fails
+func (ngm *NvidiaGPUManager) livePods() []PodRef {
+	if ngm.LivePods == nil {
+		return nil
+	}
+	pods, err := ngm.LivePods()
+	if err != nil {
+		utils.Errorf("Listing live pods fails: %v", err)
+		return nil
+	}
+	return pods
+}
+
+// liveKeys returns the keys of the live pods, including their names, which GPUs are owned by if the pod could not be
+// resolved when allocated
+func liveKeys(pods []PodRef) map[string]bool {
+	keys := make(map[string]bool)
+	for _, pod := range pods {
+		keys[pod.Key()] = true
+		keys[pod.Name] = true
+	}
+	return keys
+}
+
+// podKey resolves the pod named name, which is all the Device interface carries, to the key of a live pod, must be
+// called with lock held
+// of pods with the same name in different namespaces, the one which already owns some of the GPUs is chosen, else
+// one which owns none, the name is used if no live pod has it
+func (ngm *NvidiaGPUManager) podKey(name string, ids []string, pods []PodRef) string {
+	candidates := []string{}
+	for _, pod := range pods {
+		if pod.Name == name {
+			candidates = append(candidates, pod.Key())
+		}
+	}
+	if len(candidates) == 0 {
+		if pods != nil {
+			utils.Logf(2, "Pod %v is not among the live pods, GPUs are owned by its name", name)
+		}
+		return name
+	}
+	for _, key := range candidates {
+		for _, id := range ids {
+			if ngm.gpuOwner[id] == key {
+				return key
+			}
+		}
+	}
+	for _, key := range candidates {
+		if len(ngm.assignments[key]) == 0 {
+			return key
+		}
+	}
+	return candidates[0]
+}
+
+// releaseDeadOwners releases the pods owning any of the GPUs which are no longer alive, so that GPUs of pods which
+// have ended can be given to the next pod before garbage collection runs, must be called with lock held
+func (ngm *NvidiaGPUManager) releaseDeadOwners(podKey string, ids []string, pods []PodRef) {
+	if pods == nil {
+		return
+	}
+	live := liveKeys(pods)
+	for _, id := range ids {
+		owner, assigned := ngm.gpuOwner[id]
+		if assigned && owner != podKey && !live[owner] {
+			utils.Logf(1, "Releasing GPUs of pod %v which is no longer alive", owner)
+			ngm.releasePod(owner)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package nvidia
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+// links returns the links of a GPU to its peers, with NVLink peers, direct or through NVSwitches, at level LinkNVLink
+// must be called with lock held
+func (ngm *NvidiaGPUManager) links(id string) []nvgputypes.TopologyInfo {
+	gpu := ngm.gpus[id]
+	peers := []string{}
+	for _, nvlink := range gpu.NVLinks {
+		peers = append(peers, nvlink.BusID)
+	}
+	if gpu.NVSwitchLinks > 0 {
+		// GPUs connected to the NVSwitches reach each other through them
+		for _, peerID := range ngm.sortedByBusID() {
+			if peer := ngm.gpus[peerID]; peerID != id && peer.NVSwitchLinks > 0 {
+				peers = append(peers, peer.PCI.BusID)
+			}
+		}
+	}
+	if len(peers) == 0 {
+		return gpu.Topology
+	}
+	nvlinkPeers := make(map[string]bool)
+	for _, busID := range peers {
+		nvlinkPeers[busID] = true
+	}
+	links := []nvgputypes.TopologyInfo{}
+	for _, topolink := range gpu.Topology {
+		if nvlinkPeers[topolink.BusID] {
+			topolink.Link = nvgputypes.LinkNVLink
+			delete(nvlinkPeers, topolink.BusID)
+		}
+		links = append(links, topolink)
+	}
+	// peers reported through NVLink only
+	for _, busID := range peers {
+		if nvlinkPeers[busID] {
+			links = append(links, nvgputypes.TopologyInfo{BusID: busID, Link: nvgputypes.LinkNVLink})
+			delete(nvlinkPeers, busID)
+		}
+	}
+	return links
+}
+
+// gpuPCIPaths returns the PCI ancestry of the found GPUs from sysfs, GPUs not found in sysfs are left out
+// must be called with lock held
+func (ngm *NvidiaGPUManager) gpuPCIPaths() map[string]string {
+	paths := make(map[string]string)
+	if ngm.SysfsRoot == "" {
+		return paths
+	}
+	for _, id := range ngm.sortedByBusID() {
+		devicePath := filepath.Join(ngm.SysfsRoot, "bus", "pci", "devices", nvgputypes.NormalizeBusID(ngm.gpus[id].PCI.BusID))
+		if pciPath := pciPath(ngm.SysfsRoot, devicePath); pciPath != "" {
+			paths[id] = pciPath
+		}
+	}
+	return paths
+}
+
+// numaGroupID returns numa<node> for GPUs with a known NUMA node, must be called with lock held
+func (ngm *NvidiaGPUManager) numaGroupID(id string, pciPaths map[string]string) string {
+	if node := ngm.numaNode(id); node >= 0 {
+		return "numa" + strconv.FormatInt(node, 10)
+	}
+	return ""
+}
+
+// socketGroupID returns socket<socket> for GPUs with a known CPU socket, must be called with lock held
+func (ngm *NvidiaGPUManager) socketGroupID(id string, pciPaths map[string]string) string {
+	if socket := ngm.cpuSocket(id); socket >= 0 {
+		return "socket" + strconv.FormatInt(socket, 10)
+	}
+	return ""
+}
+
+// rootComplexGroupID returns the root complex of the GPU, e.g. pci0000_80 for pci0000:80/0000:80:01.0/...
+func rootComplexGroupID(id string, pciPaths map[string]string) string {
+	if pciPaths[id] == "" {
+		return ""
+	}
+	return strings.NewReplacer(":", "_").Replace(strings.Split(pciPaths[id], "/")[0])
+}
+
+// switchGroupID returns the PCIe switch the GPU is behind, the upstream port of the switch, named like bus IDs
+// GPUs directly below a root port are named after the root port
+func switchGroupID(id string, pciPaths map[string]string) string {
+	parts := strings.Split(pciPaths[id], "/")
+	if len(parts) < 3 {
+		return ""
+	}
+	return groupIDFromBusID(switchBusID(parts))
+}
+
+// switchBusID returns the upstream port of the PCIe switch at the end of a PCI path split into its parts, or the root
+// port for devices directly below it, the path must have at least 3 parts
+func switchBusID(parts []string) string {
+	upstream := len(parts) - 3
+	if upstream < 1 {
+		upstream = 1
+	}
+	return parts[upstream]
+}
+
+// groupIDs names the groups split from one group of the level above after the hardware their GPUs share, the NUMA
+// node, CPU socket or root complex for the top level, the PCIe switch below, the first kind of hardware known for all groups
+// and different for each is used, otherwise the groups are named after the lowest PCI bus ID among their members
+// a group whose GPUs disappear partly keeps its name as long as a GPU behind the same hardware remains, groups named
+// after a bus ID keep the name recorded for their GPUs, so that it does not change when that GPU disappears
+// must be called with lock held
+func (ngm *NvidiaGPUManager) groupIDs(groups [][]string, level int, top bool, pciPaths map[string]string) []string {
+	kinds := []func(id string, pciPaths map[string]string) string{switchGroupID}
+	if top {
+		kinds = []func(id string, pciPaths map[string]string) string{ngm.numaGroupID, ngm.socketGroupID, rootComplexGroupID}
+	}
+	for _, kind := range kinds {
+		ids := make([]string, len(groups))
+		used := make(map[string]bool)
+		for i, group := range groups {
+			for _, id := range group {
+				if groupID := kind(id, pciPaths); groupID != "" && (ids[i] == "" || groupID < ids[i]) {
+					ids[i] = groupID
+				}
+			}
+			if ids[i] == "" || used[ids[i]] {
+				ids = nil
+				break
+			}
+			used[ids[i]] = true
+		}
+		if ids != nil {
+			return ids
+		}
+	}
+	return ngm.busIDGroupIDs(groups, level)
+}
+
+// busIDGroupIDs names the groups after the bus ID of a member, the name recorded for a member at the level if it is
+// not taken by another group, else the lowest bus ID, and records the names, must be called with lock held
+func (ngm *NvidiaGPUManager) busIDGroupIDs(groups [][]string, level int) []string {
+	if ngm.groupNames == nil {
+		ngm.groupNames = make(map[string]string)
+	}
+	key := func(id string) string { return strconv.Itoa(level) + "/" + id }
+	ids := make([]string, len(groups))
+	used := make(map[string]bool)
+	for i, group := range groups {
+		candidates := []string{}
+		for _, id := range group {
+			if name := ngm.groupNames[key(id)]; name != "" {
+				candidates = append(candidates, name)
+			}
+		}
+		// the first GPU of a group has the lowest bus ID
+		for _, id := range group {
+			candidates = append(candidates, groupIDFromBusID(ngm.gpus[id].PCI.BusID))
+		}
+		ids[i] = groupIDFromBusID(ngm.gpus[group[0]].PCI.BusID)
+		for _, candidate := range candidates {
+			if !used[candidate] {
+				ids[i] = candidate
+				break
+			}
+		}
+		used[ids[i]] = true
+		for _, id := range group {
+			ngm.groupNames[key(id)] = ids[i]
+		}
+	}
+	return ids
+}
+
+// group ID used for all GPUs of fully connected systems
+const fullyConnectedGroupID = "nvswitch"
+
+// isFullyConnected returns true if there are at least two GPUs and all are connected to the NVSwitches, a mesh of
+// direct NVLinks between all GPUs is not, its links differ in number and bandwidth, must be called with lock held
+func (ngm *NvidiaGPUManager) isFullyConnected() bool {
+	ids := ngm.sortedByBusID()
+	if len(ids) < 2 {
+		return false
+	}
+	for _, id := range ids {
+		if ngm.gpus[id].NVSwitchLinks <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// flatTopologyDiscovery puts all found GPUs in a single group at the given level, must be called with lock held
+func (ngm *NvidiaGPUManager) flatTopologyDiscovery(level int32) {
+	prefix := "gpugrp" + strconv.Itoa(int(level)) + "/" + fullyConnectedGroupID
+	for id, gpu := range ngm.gpus {
+		if gpu.Found {
+			gpu.Name = prefix + "/" + gpu.Name
+			gpu.TopoDone = true
+			ngm.gpus[id] = gpu
+		}
+	}
+}
+
+// FullyConnected returns true if all GPUs are connected to the NVSwitches
+func (ngm *NvidiaGPUManager) FullyConnected() bool {
+	ngm.Lock()
+	defer ngm.Unlock()
+	return ngm.fullyConnected
+}
+
+// topologyOrder sorts GPUs so that GPUs in the same gpugrp0 are adjacent and groups are ordered by gpugrp1
+// within a group GPUs are ordered by PCI bus ID, must be called with lock held
+func (ngm *NvidiaGPUManager) topologyOrder(ids []string) []string {
+	ordered := append([]string{}, ids...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		gpuI := ngm.gpus[ordered[i]]
+		gpuJ := ngm.gpus[ordered[j]]
+		// Name is gpugrp1/<id>/gpugrp0/<id>/gpu/<uuid>, compare the group part
+		grpI := path.Dir(path.Dir(gpuI.Name))
+		grpJ := path.Dir(path.Dir(gpuJ.Name))
+		if grpI != grpJ {
+			return grpI < grpJ
+		}
+		return groupIDFromBusID(gpuI.PCI.BusID) < groupIDFromBusID(gpuJ.PCI.BusID)
+	})
+	return ordered
+}
+
+// linkBetween returns the link level from GPU idI to GPU idJ, or -1 if unknown, must be called with lock held
+func (ngm *NvidiaGPUManager) linkBetween(idI string, idJ string) int32 {
+	for _, topolink := range ngm.links(idI) {
+		if ngm.busIDToID[topolink.BusID] == idJ {
+			return topolink.Link
+		}
+	}
+	return -1
+}
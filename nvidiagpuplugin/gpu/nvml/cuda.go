@@ -0,0 +1,60 @@
+package nvml
+
+import (
+	"strconv"
+
+	gputypes "github.com/Microsoft/KubeGPU/gpuplugintypes"
+)
+
+// minimum Linux driver version for each CUDA toolkit release, newest first
+// see the CUDA toolkit release notes, used when NVML cannot report the CUDA driver version
+var cudaDriverVersions = []struct {
+	cuda   string
+	driver string
+}{
+	{"12.4", "550.54.14"},
+	{"12.3", "545.23.06"},
+	{"12.2", "535.54.03"},
+	{"12.1", "530.30.02"},
+	{"12.0", "525.60.13"},
+	{"11.8", "520.61.05"},
+	{"11.7", "515.43.04"},
+	{"11.6", "510.39.01"},
+	{"11.5", "495.29.05"},
+	{"11.4", "470.42.01"},
+	{"11.3", "465.19.01"},
+	{"11.2", "460.27.03"},
+	{"11.1", "455.23"},
+	{"11.0", "450.36.06"},
+	{"10.2", "440.33"},
+	{"10.1", "418.39"},
+	{"10.0", "410.48"},
+	{"9.2", "396.26"},
+	{"9.1", "390.46"},
+	{"9.0", "384.81"},
+	{"8.0", "375.26"},
+}
+
+// cudaVersionFromDriver returns the highest CUDA version the driver version supports, or "" if unknown
+func cudaVersionFromDriver(driver string) string {
+	for _, entry := range cudaDriverVersions {
+		cmp, err := gputypes.CompareVersions(driver, entry.driver)
+		if err != nil {
+			return ""
+		}
+		if cmp >= 0 {
+			return entry.cuda
+		}
+	}
+	return ""
+}
+
+// cudaVersion returns the highest CUDA version supported by the installed driver, as reported by NVML
+// falls back to the version implied by the driver version on drivers which do not report it
+func cudaVersion(lib Lib, driver string) string {
+	major, minor, err := lib.GetCudaDriverVersion()
+	if err == nil && major != nil && minor != nil && *major > 0 {
+		return strconv.Itoa(int(*major)) + "." + strconv.Itoa(int(*minor))
+	}
+	return cudaVersionFromDriver(driver)
+}
@@ -0,0 +1,80 @@
+package nvml
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+)
+
+// nvmlEventTypeDoubleBitEccError from nvml.h, not exported by the bindings
+const eventTypeDoubleBitEccError = 0x2
+
+// error string of NVML_ERROR_TIMEOUT, returned by nvmlEventSetWait if no event arrives in time
+const nvmlTimeout = "Timeout"
+
+// EventSource is a HealthEventSource backed by an NVML event set
+type EventSource struct {
+	initialized bool
+	eventSet    nvml.EventSet
+}
+
+func NewEventSource() *EventSource {
+	return &EventSource{}
+}
+
+func (es *EventSource) Register(uuids []string) error {
+	if !es.initialized {
+		if err := nvml.Init(); err != nil {
+			return err
+		}
+		es.eventSet = nvml.NewEventSet()
+		es.initialized = true
+	}
+	for _, uuid := range uuids {
+		if err := nvml.RegisterEventForDevice(es.eventSet, nvml.XidCriticalError, uuid); err != nil {
+			return fmt.Errorf("registering XID events for %v fails: %v", uuid, err)
+		}
+		// ECC events are not supported on all devices, so ignore failures here
+		_ = nvml.RegisterEventForDevice(es.eventSet, eventTypeDoubleBitEccError, uuid)
+	}
+	return nil
+}
+
+func (es *EventSource) Wait(timeout time.Duration) (*nvgputypes.HealthEvent, error) {
+	if !es.initialized {
+		time.Sleep(timeout)
+		return nil, nil
+	}
+	e, err := nvml.WaitForEvent(es.eventSet, uint(timeout/time.Millisecond))
+	if err != nil {
+		// timeout is reported as an error by NVML
+		if strings.Contains(err.Error(), nvmlTimeout) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if e.UUID == nil {
+		return nil, nil
+	}
+	event := &nvgputypes.HealthEvent{UUID: *e.UUID, Data: e.Edata}
+	switch e.Etype {
+	case nvml.XidCriticalError:
+		event.Type = nvgputypes.HealthEventXid
+	case eventTypeDoubleBitEccError:
+		event.Type = nvgputypes.HealthEventDoubleBitECC
+	default:
+		return nil, nil
+	}
+	return event, nil
+}
+
+func (es *EventSource) Close() {
+	if es.initialized {
+		nvml.DeleteEventSet(es.eventSet)
+		nvml.Shutdown()
+		es.initialized = false
+	}
+}
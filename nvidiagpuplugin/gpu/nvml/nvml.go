@@ -2,18 +2,109 @@ package nvml
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
 	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
 )
 
-// GetDevices returns the device information
-func GetDevices() (*nvgputypes.GpusInfo, error) {
-	err := nvml.Init()
+// Lib is the subset of NVML used for discovery, it can be replaced by fixtures in tests
+type Lib interface {
+	Init() error
+	Shutdown() error
+	GetDeviceCount() (uint, error)
+	NewDevice(idx uint) (*nvml.Device, error)
+	GetP2PLink(dev1 *nvml.Device, dev2 *nvml.Device) (nvml.P2PLinkType, error)
+	// GetNVLink returns SingleNVLINKLink ... SixNVLINKLinks, or P2PLinkUnknown if there is no NVLink
+	// NVML reports the remote end of the links, on NVSwitch systems dev2 has to be a switch, not a peer GPU
+	GetNVLink(dev1 *nvml.Device, dev2 *nvml.Device) (nvml.P2PLinkType, error)
+	GetDriverVersion() (string, error)
+	// GetCudaDriverVersion returns the major and minor CUDA version supported by the driver
+	GetCudaDriverVersion() (*uint, *uint, error)
+}
+
+type nvmlLib struct{}
+
+func (nvmlLib) Init() error                              { return nvml.Init() }
+func (nvmlLib) Shutdown() error                          { return nvml.Shutdown() }
+func (nvmlLib) GetDeviceCount() (uint, error)            { return nvml.GetDeviceCount() }
+func (nvmlLib) NewDevice(idx uint) (*nvml.Device, error) { return nvml.NewDevice(idx) }
+func (nvmlLib) GetDriverVersion() (string, error)        { return nvml.GetDriverVersion() }
+func (nvmlLib) GetCudaDriverVersion() (*uint, *uint, error) {
+	return nvml.GetCudaDriverVersion()
+}
+func (nvmlLib) GetP2PLink(dev1 *nvml.Device, dev2 *nvml.Device) (nvml.P2PLinkType, error) {
+	return nvml.GetP2PLink(dev1, dev2)
+}
+func (nvmlLib) GetNVLink(dev1 *nvml.Device, dev2 *nvml.Device) (nvml.P2PLinkType, error) {
+	return nvml.GetNVLink(dev1, dev2)
+}
+
+// bandwidth per NVLink per direction in bytes/s, NVLink 1.0 (Pascal) is slower than later generations
+func nvlinkBandwidth(model string) int64 {
+	if strings.Contains(model, "P100") {
+		return 20 * 1000 * 1000 * 1000
+	}
+	return 25 * 1000 * 1000 * 1000
+}
+
+// CUDA cores and peak memory bandwidth in bytes/s of known models, neither is reported by NVML
+// newer drivers prefix the model names with "NVIDIA ", which is stripped before the lookup
+var modelSpecs = map[string]struct {
+	cores     int64
+	bandwidth int64
+}{
+	"Tesla K80":            {2496, 240 * 1000 * 1000 * 1000},
+	"Tesla P100-PCIE-16GB": {3584, 732 * 1000 * 1000 * 1000},
+	"Tesla P100-SXM2-16GB": {3584, 732 * 1000 * 1000 * 1000},
+	"Tesla V100-PCIE-16GB": {5120, 900 * 1000 * 1000 * 1000},
+	"Tesla V100-PCIE-32GB": {5120, 900 * 1000 * 1000 * 1000},
+	"Tesla V100-SXM2-16GB": {5120, 900 * 1000 * 1000 * 1000},
+	"Tesla V100-SXM2-32GB": {5120, 900 * 1000 * 1000 * 1000},
+	"Tesla V100-SXM3-32GB": {5120, 980 * 1000 * 1000 * 1000},
+	"Tesla T4":             {2560, 320 * 1000 * 1000 * 1000},
+	"A100-SXM4-40GB":       {6912, 1555 * 1000 * 1000 * 1000},
+	"A100-SXM4-80GB":       {6912, 2039 * 1000 * 1000 * 1000},
+	"A100-PCIE-40GB":       {6912, 1555 * 1000 * 1000 * 1000},
+	"A100 80GB PCIe":       {6912, 1935 * 1000 * 1000 * 1000},
+	"H100 80GB HBM3":       {16896, 3350 * 1000 * 1000 * 1000},
+	"H100 PCIe":            {14592, 2000 * 1000 * 1000 * 1000},
+}
+
+// architecture family by compute capability major version
+var families = map[int]string{
+	2: "Fermi",
+	3: "Kepler",
+	5: "Maxwell",
+	6: "Pascal",
+	7: "Volta",
+	8: "Ampere",
+	9: "Hopper",
+}
+
+func family(major int, minor int) string {
+	if major == 7 && minor >= 5 {
+		return "Turing"
+	}
+	if major == 8 && minor >= 9 {
+		return "Ada"
+	}
+	return families[major]
+}
+
+// GetDevices returns the device information, the NUMA locality of the devices is read from sysfs below sysfsRoot
+func GetDevices(sysfsRoot string) (*nvgputypes.GpusInfo, error) {
+	return GetDevicesFromLib(nvmlLib{}, sysfsRoot)
+}
+
+// GetDevicesFromLib returns the device information using the given NVML implementation
+func GetDevicesFromLib(lib Lib, sysfsRoot string) (*nvgputypes.GpusInfo, error) {
+	err := lib.Init()
 	nvmlFound := false
 	shutDown := func() {
 		if nvmlFound {
-			nvml.Shutdown()
+			lib.Shutdown()
 		}
 	}
 	defer shutDown()
@@ -22,38 +113,57 @@ func GetDevices() (*nvgputypes.GpusInfo, error) {
 		return nil, err
 	}
 	nvmlFound = true
-	numGpus, err := nvml.GetDeviceCount()
+	numGpus, err := lib.GetDeviceCount()
 	if err != nil {
 		return nil, err
 	}
 	var devices []nvml.Device
 	for i := uint(0); i < numGpus; i++ {
-		dev, err := nvml.NewDevice(i)
+		dev, err := lib.NewDevice(i)
 		if err != nil {
 			return nil, err
 		}
 		devices = append(devices, *dev)
 	}
+	nvlinks := make([][]nvgputypes.NVLinkInfo, numGpus)
+	nvswitchLinks := make([]int32, numGpus)
+	nvswitches := nvswitchBusIDs(sysfsRoot)
 	for i := uint(0); i < numGpus; i++ {
+		for _, busID := range nvswitches {
+			nvswitch := nvml.Device{PCI: nvml.PCIInfo{BusID: busID}}
+			nvlinkType, err := lib.GetNVLink(&devices[i], &nvswitch)
+			if err == nil && nvlinkType >= nvml.SingleNVLINKLink {
+				nvswitchLinks[i] += int32(nvlinkType-nvml.SingleNVLINKLink) + 1
+			}
+		}
 		for j := uint(0); j < numGpus; j++ {
 			topo := nvml.P2PLink{BusID: devices[j].PCI.BusID, Link: nvml.P2PLinkUnknown}
 			if i != j {
-				topoType, err := nvml.GetP2PLink(&devices[i], &devices[j])
+				topoType, err := lib.GetP2PLink(&devices[i], &devices[j])
 				if err != nil {
 					return nil, err
 				}
 				topo.Link = topoType
+				nvlinkType, err := lib.GetNVLink(&devices[i], &devices[j])
+				if err == nil && nvlinkType >= nvml.SingleNVLINKLink {
+					numLinks := int32(nvlinkType-nvml.SingleNVLINKLink) + 1
+					nvlinks[i] = append(nvlinks[i], nvgputypes.NVLinkInfo{
+						BusID:     devices[j].PCI.BusID,
+						Links:     numLinks,
+						Bandwidth: int64(numLinks) * nvlinkBandwidth(*devices[i].Model),
+					})
+				}
 			}
 			devices[i].Topology = append(devices[i].Topology, topo)
 		}
 	}
 
 	gpus := &nvgputypes.GpusInfo{}
-	gpus.Version.Driver, err = nvml.GetDriverVersion()
+	gpus.Version.Driver, err = lib.GetDriverVersion()
 	if err != nil {
 		return nil, err
 	}
-	gpus.Version.CUDA = "" // unsupported for now
+	gpus.Version.CUDA = cudaVersion(lib, gpus.Version.Driver)
 	for i := uint(0); i < numGpus; i++ {
 		gpu := nvgputypes.GpuInfo{}
 		gpu.ID = devices[i].UUID
@@ -66,6 +176,30 @@ func GetDevices() (*nvgputypes.GpusInfo, error) {
 			BusID:     devices[i].PCI.BusID,
 			Bandwidth: int64(*devices[i].PCI.Bandwidth) * int64(1000) * int64(1000), // MB
 		}
+		// optional attributes, not reported on all devices
+		if devices[i].Power != nil {
+			gpu.Power = int64(*devices[i].Power)
+		}
+		if devices[i].CPUAffinity != nil {
+			affinity := int64(*devices[i].CPUAffinity)
+			gpu.CPUAffinity = &affinity
+		}
+		if devices[i].Clocks.Cores != nil {
+			gpu.Clocks.Cores = int64(*devices[i].Clocks.Cores)
+		}
+		if devices[i].Clocks.Memory != nil {
+			gpu.Clocks.Memory = int64(*devices[i].Clocks.Memory)
+		}
+		if spec, ok := modelSpecs[strings.TrimPrefix(gpu.Model, "NVIDIA ")]; ok {
+			gpu.Cores = spec.cores
+			gpu.Memory.Bandwidth = spec.bandwidth
+		}
+		gpu.NUMANode, gpu.CPUSet = pciLocality(sysfsRoot, devices[i].PCI.BusID)
+		cc := devices[i].CudaComputeCapability
+		if cc.Major != nil && cc.Minor != nil {
+			gpu.Arch = fmt.Sprintf("%d.%d", *cc.Major, *cc.Minor)
+			gpu.Family = family(*cc.Major, *cc.Minor)
+		}
 		var topos []nvgputypes.TopologyInfo
 		for j := uint(0); j < numGpus; j++ {
 			if i != j {
@@ -76,6 +210,8 @@ func GetDevices() (*nvgputypes.GpusInfo, error) {
 			}
 		}
 		gpu.Topology = topos
+		gpu.NVLinks = nvlinks[i]
+		gpu.NVSwitchLinks = nvswitchLinks[i]
 		gpus.Gpus = append(gpus.Gpus, gpu)
 	}
 
@@ -83,8 +219,8 @@ func GetDevices() (*nvgputypes.GpusInfo, error) {
 }
 
 // GetDevicesJSON returns the device information as a JSON string
-func GetDevicesJSON() []byte {
-	gpus, err := GetDevices()
+func GetDevicesJSON(sysfsRoot string) []byte {
+	gpus, err := GetDevices(sysfsRoot)
 	if err != nil {
 		return nil
 	} else {
@@ -0,0 +1,196 @@
+package nvml
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+)
+
+// fakeLib is a fixture of four V100s on one PCIe host bridge, with NVLinks 0-1 (two links) and 2-3 (one link)
+type fakeLib struct {
+	nvlinks map[[2]uint]nvml.P2PLinkType
+}
+
+func (fakeLib) Init() error                   { return nil }
+func (fakeLib) Shutdown() error               { return nil }
+func (fakeLib) GetDeviceCount() (uint, error) { return 4, nil }
+func (fakeLib) GetDriverVersion() (string, error) {
+	return "418.87.01", nil
+}
+
+func (fakeLib) GetCudaDriverVersion() (*uint, *uint, error) {
+	return nil, nil, errors.New("Function Not Found")
+}
+
+func (fakeLib) NewDevice(idx uint) (*nvml.Device, error) {
+	model := "Tesla V100-SXM2-16GB"
+	memory := uint64(16160)
+	bandwidth := uint(15760)
+	return &nvml.Device{
+		UUID:   "GPU-" + strconv.Itoa(int(idx)),
+		Path:   "/dev/nvidia" + strconv.Itoa(int(idx)),
+		Model:  &model,
+		Memory: &memory,
+		PCI:    nvml.PCIInfo{BusID: "00000000:0" + strconv.Itoa(int(idx)) + ":00.0", Bandwidth: &bandwidth},
+	}, nil
+}
+
+func (fakeLib) GetP2PLink(dev1 *nvml.Device, dev2 *nvml.Device) (nvml.P2PLinkType, error) {
+	return nvml.P2PLinkHostBridge, nil
+}
+
+func (lib fakeLib) GetNVLink(dev1 *nvml.Device, dev2 *nvml.Device) (nvml.P2PLinkType, error) {
+	i, _ := strconv.Atoi(dev1.UUID[4:])
+	j, _ := strconv.Atoi(dev2.UUID[4:])
+	return lib.nvlinks[[2]uint{uint(i), uint(j)}], nil
+}
+
+func TestGetDevicesNVLink(t *testing.T) {
+	lib := fakeLib{nvlinks: map[[2]uint]nvml.P2PLinkType{
+		{0, 1}: nvml.TwoNVLINKLinks,
+		{1, 0}: nvml.TwoNVLINKLinks,
+		{2, 3}: nvml.SingleNVLINKLink,
+		{3, 2}: nvml.SingleNVLINKLink,
+	}}
+	gpus, err := GetDevicesFromLib(lib, filepath.Join(os.TempDir(), "no-sysfs"))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if len(gpus.Gpus) != 4 || gpus.Version.Driver != "418.87.01" || gpus.Version.CUDA != "10.1" {
+		t.Fatalf("Unexpected devices %+v", gpus)
+	}
+	expected := []nvgputypes.NVLinkInfo{{BusID: "00000000:01:00.0", Links: 2, Bandwidth: 50 * 1000 * 1000 * 1000}}
+	if !reflect.DeepEqual(gpus.Gpus[0].NVLinks, expected) {
+		t.Errorf("Unexpected NVLinks for GPU 0: %+v", gpus.Gpus[0].NVLinks)
+	}
+	expected = []nvgputypes.NVLinkInfo{{BusID: "00000000:02:00.0", Links: 1, Bandwidth: 25 * 1000 * 1000 * 1000}}
+	if !reflect.DeepEqual(gpus.Gpus[3].NVLinks, expected) {
+		t.Errorf("Unexpected NVLinks for GPU 3: %+v", gpus.Gpus[3].NVLinks)
+	}
+	if gpus.Gpus[0].Cores != 5120 || gpus.Gpus[0].Memory.Bandwidth != 900*1000*1000*1000 {
+		t.Errorf("Unexpected cores %v and memory bandwidth %v of known model", gpus.Gpus[0].Cores, gpus.Gpus[0].Memory.Bandwidth)
+	}
+	if len(gpus.Gpus[0].Topology) != 3 || gpus.Gpus[0].Topology[0].Link != int32(nvml.P2PLinkHostBridge) {
+		t.Errorf("Unexpected topology %+v", gpus.Gpus[0].Topology)
+	}
+}
+
+// nvswitchLib models an NVSwitch system, the GPU NVLinks end at the switches, one link to each
+type nvswitchLib struct {
+	fakeLib
+	switches map[string]bool
+}
+
+func (lib nvswitchLib) GetNVLink(dev1 *nvml.Device, dev2 *nvml.Device) (nvml.P2PLinkType, error) {
+	if lib.switches[dev2.PCI.BusID] {
+		return nvml.SingleNVLINKLink, nil
+	}
+	return nvml.P2PLinkUnknown, nil
+}
+
+func TestGetDevicesNVSwitch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// six NVSwitches and a device of another vendor with the same class
+	addDevice := func(busID string, vendor string, class string) {
+		devDir := filepath.Join(dir, "bus", "pci", "devices", busID)
+		os.MkdirAll(devDir, 0755)
+		ioutil.WriteFile(filepath.Join(devDir, "vendor"), []byte(vendor+"\n"), 0644)
+		ioutil.WriteFile(filepath.Join(devDir, "class"), []byte(class+"\n"), 0644)
+	}
+	lib := nvswitchLib{switches: make(map[string]bool)}
+	for i := 0; i < 6; i++ {
+		busID := "0000:e" + strconv.Itoa(i) + ":00.0"
+		addDevice(busID, "0x10de", "0x068000")
+		lib.switches["00000000:E"+strconv.Itoa(i)+":00.0"] = true
+	}
+	addDevice("0000:f0:00.0", "0x8086", "0x068000")
+	lib.switches["00000000:F0:00.0"] = true
+
+	gpus, err := GetDevicesFromLib(lib, dir)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	for _, gpu := range gpus.Gpus {
+		if gpu.NVSwitchLinks != 6 || len(gpu.NVLinks) != 0 {
+			t.Errorf("Expected 6 links to the switches and no direct NVLinks, have %+v", gpu)
+		}
+	}
+}
+
+func TestPCILocality(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	devDir := filepath.Join(dir, "bus", "pci", "devices", "0000:8a:00.0")
+	os.MkdirAll(devDir, 0755)
+	ioutil.WriteFile(filepath.Join(devDir, "numa_node"), []byte("1\n"), 0644)
+	ioutil.WriteFile(filepath.Join(devDir, "local_cpulist"), []byte("14-27,42-55\n"), 0644)
+
+	node, cpus := pciLocality(dir, "00000000:8A:00.0")
+	if node == nil || *node != 1 || cpus != "14-27,42-55" {
+		t.Errorf("Unexpected locality %v %v", node, cpus)
+	}
+	ioutil.WriteFile(filepath.Join(devDir, "numa_node"), []byte("-1\n"), 0644)
+	if node, _ := pciLocality(dir, "00000000:8A:00.0"); node != nil {
+		t.Errorf("Expected unknown NUMA node, have %v", *node)
+	}
+	if node, cpus := pciLocality(dir, "00000000:01:00.0"); node != nil || cpus != "" {
+		t.Errorf("Expected unknown locality for missing device")
+	}
+}
+
+type cudaLib struct {
+	fakeLib
+}
+
+func (cudaLib) GetCudaDriverVersion() (*uint, *uint, error) {
+	major, minor := uint(12), uint(2)
+	return &major, &minor, nil
+}
+
+func TestCUDAVersion(t *testing.T) {
+	if version := cudaVersion(cudaLib{}, "535.104.05"); version != "12.2" {
+		t.Errorf("Expected CUDA version from NVML, have %v", version)
+	}
+	cases := map[string]string{
+		"384.111":   "9.0",
+		"418.87.01": "10.1",
+		"470.42.01": "11.4",
+		"535.54.03": "12.2",
+		"367.48":    "",
+		"":          "",
+	}
+	for driver, expected := range cases {
+		if version := cudaVersion(fakeLib{}, driver); version != expected {
+			t.Errorf("Driver %v, expected CUDA version %v, have %v", driver, expected, version)
+		}
+	}
+}
+
+func TestParseRetiredPages(t *testing.T) {
+	output := "GPU-0, 2, 1, No\nGPU-1, 0, 0, Yes\nGPU-2, [N/A], [N/A], [N/A]\n"
+	pages, err := parseRetiredPages(output)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := map[string]nvgputypes.RetiredPages{"GPU-0": {Count: 3}, "GPU-1": {Pending: true}, "GPU-2": {}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Wrong retired pages, expected %v have %v", expected, pages)
+	}
+	if _, err := parseRetiredPages("GPU-0, 2\n"); err == nil {
+		t.Errorf("Expected error for malformed line")
+	}
+}
@@ -0,0 +1,60 @@
+package nvml
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+// RetiredPagesSource is a RetiredPagesSource which queries nvidia-smi, which reads the page retirement status of the
+// devices from NVML
+type RetiredPagesSource struct {
+	Command string
+}
+
+func NewRetiredPagesSource() *RetiredPagesSource {
+	return &RetiredPagesSource{Command: "nvidia-smi"}
+}
+
+func (rs *RetiredPagesSource) RetiredPages() (map[string]nvgputypes.RetiredPages, error) {
+	output, err := exec.Command(rs.Command, "--query-gpu=uuid,retired_pages.sbe,retired_pages.dbe,retired_pages.pending",
+		"--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseRetiredPages(string(output))
+}
+
+// parseRetiredPages parses lines of uuid, single bit and double bit ECC retired pages, and pending status
+// counts are "[N/A]" or "[Not Supported]" on devices without page retirement, these have no retired pages
+func parseRetiredPages(output string) (map[string]nvgputypes.RetiredPages, error) {
+	pages := make(map[string]nvgputypes.RetiredPages)
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected retired pages line %q", line)
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		status := nvgputypes.RetiredPages{Pending: fields[3] == "Yes"}
+		for _, field := range fields[1:3] {
+			if strings.HasPrefix(field, "[") {
+				continue
+			}
+			count, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected retired pages count in line %q: %v", line, err)
+			}
+			status.Count += count
+		}
+		pages[fields[0]] = status
+	}
+	return pages, nil
+}
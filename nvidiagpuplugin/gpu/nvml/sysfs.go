@@ -0,0 +1,65 @@
+package nvml
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+// PCI vendor of NVIDIA devices and class of NVSwitches, a bridge of class "other"
+const (
+	pciVendorNVIDIA     = "0x10de"
+	pciClassNVSwitchPfx = "0x0680"
+)
+
+// nvswitchBusIDs returns the bus IDs of the NVSwitches found in sysfs below sysfsRoot, in the NVML format
+func nvswitchBusIDs(sysfsRoot string) []string {
+	busIDs := []string{}
+	dir := filepath.Join(sysfsRoot, "bus", "pci", "devices")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return busIDs
+	}
+	for _, entry := range entries {
+		vendor, err := ioutil.ReadFile(filepath.Join(dir, entry.Name(), "vendor"))
+		if err != nil || strings.TrimSpace(string(vendor)) != pciVendorNVIDIA {
+			continue
+		}
+		class, err := ioutil.ReadFile(filepath.Join(dir, entry.Name(), "class"))
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(string(class)), pciClassNVSwitchPfx) {
+			continue
+		}
+		busIDs = append(busIDs, nvmlBusID(entry.Name()))
+	}
+	return busIDs
+}
+
+// nvmlBusID returns a bus ID in the NVML format, upper case with an 8 digit domain, e.g. 00000000:04:00.0
+func nvmlBusID(busID string) string {
+	parts := strings.SplitN(strings.ToUpper(nvgputypes.NormalizeBusID(busID)), ":", 2)
+	if len(parts) != 2 {
+		return busID
+	}
+	for len(parts[0]) < 8 {
+		parts[0] = "0" + parts[0]
+	}
+	return parts[0] + ":" + parts[1]
+}
+
+// pciLocality returns the NUMA node and local CPU list of a PCI device from sysfs below sysfsRoot
+// node is nil and cpus empty if not known, e.g. on single node systems which report NUMA node -1
+func pciLocality(sysfsRoot string, busID string) (node *int64, cpus string) {
+	dir := filepath.Join(sysfsRoot, "bus", "pci", "devices", nvgputypes.NormalizeBusID(busID))
+	if body, err := ioutil.ReadFile(filepath.Join(dir, "numa_node")); err == nil {
+		if val, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64); err == nil && val >= 0 {
+			node = &val
+		}
+	}
+	if body, err := ioutil.ReadFile(filepath.Join(dir, "local_cpulist")); err == nil {
+		cpus = strings.TrimSpace(string(body))
+	}
+	return node, cpus
+}
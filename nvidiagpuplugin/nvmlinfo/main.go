@@ -1,68 +1,337 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/Microsoft/KubeDevice-API/pkg/types"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvidia"
 	mnvml "github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvml"
 	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
 )
 
-func printInfo() {
-	err := nvml.Init()
-	nvmlFound := false
-	shutDown := func() {
-		fmt.Printf("Performing NVML Shutdown\n")
-		if nvmlFound {
-			nvml.Shutdown()
+// exit codes
+const (
+	exitOK        = 0
+	exitError     = 1 // NVML or discovery error
+	exitUsage     = 2 // unknown subcommand or invalid flags
+	exitUnhealthy = 3 // health found a device with errors
+)
+
+const usage = `Usage: nvmlinfo <command> [flags]
+
+Commands:
+  json     print the discovered devices as JSON, the format read by the device plugin
+  table    print a summary of the discovered devices
+  topo     print the link matrix between devices, like nvidia-smi topo -m
+  groups   print the gpugrp1/gpugrp0 groups advertised for the devices
+  health   print ECC errors and XID events of the devices
+`
+
+var commands = map[string]func(args []string) int{
+	"json":   runJSON,
+	"table":  runTable,
+	"topo":   runTopo,
+	"groups": runGroups,
+	"health": runHealth,
+}
+
+// sysfsFlag adds the flag for the root of sysfs the NUMA locality of the devices is read from
+func sysfsFlag(flags *flag.FlagSet) *string {
+	return flags.String("sysfs", nvgputypes.DefaultSysfsRoot, "root of sysfs the NUMA node, local CPUs and PCI path of the devices are read from")
+}
+
+func getDevices(sysfsRoot string) (*nvgputypes.GpusInfo, bool) {
+	gpus, err := mnvml.GetDevices(sysfsRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting devices: %v\n", err)
+		return nil, false
+	}
+	return gpus, true
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) bool {
+	if err := flags.Parse(args); err != nil {
+		return false
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments %v\n", flags.Args())
+		return false
+	}
+	return true
+}
+
+func runJSON(args []string) int {
+	flags := flag.NewFlagSet("json", flag.ContinueOnError)
+	sysfsRoot := sysfsFlag(flags)
+	if !parseFlags(flags, args) {
+		return exitUsage
+	}
+	body := mnvml.GetDevicesJSON(*sysfsRoot)
+	if body == nil {
+		fmt.Fprintf(os.Stderr, "Error getting devices\n")
+		return exitError
+	}
+	fmt.Printf("%s", string(body))
+	return exitOK
+}
+
+func optional(val *int64) string {
+	if val == nil {
+		return "-"
+	}
+	return strconv.FormatInt(*val, 10)
+}
+
+func runTable(args []string) int {
+	flags := flag.NewFlagSet("table", flag.ContinueOnError)
+	sysfsRoot := sysfsFlag(flags)
+	if !parseFlags(flags, args) {
+		return exitUsage
+	}
+	gpus, ok := getDevices(*sysfsRoot)
+	if !ok {
+		return exitError
+	}
+	fmt.Printf("Driver: %v CUDA: %v\n", gpus.Version.Driver, gpus.Version.CUDA)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "IDX\tUUID\tMODEL\tBUS ID\tMEMORY (MiB)\tARCH\tNUMA\tCPUS\n")
+	for i, gpu := range gpus.Gpus {
+		cpus := gpu.CPUSet
+		if cpus == "" {
+			cpus = "-"
 		}
+		fmt.Fprintf(w, "%d\t%v\t%v\t%v\t%d\t%v\t%v\t%v\n", i, gpu.ID, gpu.Model, gpu.PCI.BusID,
+			gpu.Memory.Global/(1024*1024), gpu.Arch, optional(gpu.NUMANode), cpus)
 	}
-	defer shutDown()
-	if err != nil {
-		fmt.Printf("Error initializing NVML: %v\n", err)
-		os.Exit(1)
+	w.Flush()
+	return exitOK
+}
+
+// link names as used by nvidia-smi topo -m, indexed by link level
+var linkNames = map[int32]string{
+	1: "SYS",
+	2: "NODE",
+	3: "PHB",
+	4: "PXB",
+	5: "PIX",
+	6: "BRD",
+}
+
+const linkLegend = `Legend:
+  X    = self
+  SYS  = connection traversing PCIe and the interconnect between CPUs
+  NODE = connection traversing PCIe and the interconnect between host bridges of one CPU
+  PHB  = connection traversing PCIe and a host bridge
+  PXB  = connection traversing multiple PCIe switches, without a host bridge
+  PIX  = connection traversing a single PCIe switch
+  BRD  = devices on the same board
+  NV#  = connection traversing a bonded set of # NVLinks
+`
+
+// linkName returns the name of the link from gpu to the peer
+// peers not listed in the topology are across CPUs, GPUs both linked to the NVSwitches reach each other through them
+func linkName(gpu nvgputypes.GpuInfo, peer nvgputypes.GpuInfo) string {
+	peerBusID := peer.PCI.BusID
+	if gpu.NVSwitchLinks > 0 && peer.NVSwitchLinks > 0 {
+		return "NV" + strconv.Itoa(int(gpu.NVSwitchLinks))
+	}
+	for _, nvlink := range gpu.NVLinks {
+		if nvlink.BusID == peerBusID {
+			return "NV" + strconv.Itoa(int(nvlink.Links))
+		}
+	}
+	for _, topolink := range gpu.Topology {
+		if topolink.BusID == peerBusID {
+			if name, ok := linkNames[topolink.Link]; ok {
+				return name
+			}
+			return "?"
+		}
+	}
+	return linkNames[1]
+}
+
+func runTopo(args []string) int {
+	flags := flag.NewFlagSet("topo", flag.ContinueOnError)
+	sysfsRoot := sysfsFlag(flags)
+	if !parseFlags(flags, args) {
+		return exitUsage
 	}
-	fmt.Printf("Initialized NVML\n")
-	nvmlFound = true
+	gpus, ok := getDevices(*sysfsRoot)
+	if !ok {
+		return exitError
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for i := range gpus.Gpus {
+		fmt.Fprintf(w, "\tGPU%d", i)
+	}
+	fmt.Fprintf(w, "\tNUMA\tCPUS\n")
+	for i, gpu := range gpus.Gpus {
+		fmt.Fprintf(w, "GPU%d", i)
+		for j, peer := range gpus.Gpus {
+			if i == j {
+				fmt.Fprintf(w, "\tX")
+			} else {
+				fmt.Fprintf(w, "\t%v", linkName(gpu, peer))
+			}
+		}
+		fmt.Fprintf(w, "\t%v\t%v\n", optional(gpu.NUMANode), gpu.CPUSet)
+	}
+	w.Flush()
+	fmt.Printf("\n%s", linkLegend)
+	return exitOK
+}
+
+func runGroups(args []string) int {
+	flags := flag.NewFlagSet("groups", flag.ContinueOnError)
+	sysfsRoot := sysfsFlag(flags)
+	policyPath := flags.String("grouping-policy", "/etc/kubegpu/grouping.json", "grouping policy file, the default policy is used if it does not exist")
+	if !parseFlags(flags, args) {
+		return exitUsage
+	}
+	gpus, ok := getDevices(*sysfsRoot)
+	if !ok {
+		return exitError
+	}
+	// the fake plugin reports the units of the nvidia docker plugin
+	nvidia.ToPluginUnits(gpus)
+	d, _ := nvidia.NewFakeNvidiaGPUManager(gpus, "", "")
+	ngm := d.(*nvidia.NvidiaGPUManager)
+	// the devices are those of this host, groups are named after the hardware found in sysfs
+	ngm.SysfsRoot = *sysfsRoot
+	if _, err := os.Stat(*policyPath); err == nil {
+		policy, err := nvidia.LoadGroupingPolicy(*policyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading grouping policy: %v\n", err)
+			return exitError
+		}
+		ngm.GroupingPolicy = policy
+	}
+	nodeInfo := types.NewNodeInfo()
+	if err := ngm.UpdateNodeInfo(nodeInfo); err != nil {
+		fmt.Fprintf(os.Stderr, "Error computing groups: %v\n", err)
+		return exitError
+	}
+	names := []string{}
+	for res := range nodeInfo.Capacity {
+		name := string(res)
+		if strings.HasPrefix(name, types.DeviceGroupPrefix+"/") && strings.HasSuffix(name, "/cards") {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(name, types.DeviceGroupPrefix+"/"), "/cards"))
+		}
+	}
+	sort.Strings(names)
+	// print as a tree, names are gpugrp1/<id>/gpugrp0/<id>/gpu/<uuid>
+	printed := make(map[string]bool)
+	for _, name := range names {
+		parts := strings.Split(name, "/")
+		for level := 0; level+1 < len(parts); level += 2 {
+			prefix := strings.Join(parts[:level+2], "/")
+			if !printed[prefix] {
+				fmt.Printf("%s%s/%s\n", strings.Repeat("  ", level/2), parts[level], parts[level+1])
+				printed[prefix] = true
+			}
+		}
+	}
+	return exitOK
+}
+
+func runHealth(args []string) int {
+	flags := flag.NewFlagSet("health", flag.ContinueOnError)
+	wait := flags.Duration("wait", 0, "how long to listen for XID and ECC events")
+	if !parseFlags(flags, args) {
+		return exitUsage
+	}
+	if err := nvml.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing NVML: %v\n", err)
+		return exitError
+	}
+	defer nvml.Shutdown()
 	numGpus, err := nvml.GetDeviceCount()
 	if err != nil {
-		fmt.Printf("Error gettting device count %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Error getting device count: %v\n", err)
+		return exitError
 	}
-	var devices []nvml.Device
+	exitCode := exitOK
+	uuids := []string{}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "IDX\tUUID\tBUS ID\tECC ERRORS\tTEMP (C)\n")
 	for i := uint(0); i < numGpus; i++ {
 		dev, err := nvml.NewDevice(i)
 		if err != nil {
-			fmt.Printf("Error getting info on device %d, err: %v\n", i, err)
-		} else {
-			devices = append(devices, *dev)
+			fmt.Fprintf(os.Stderr, "Error getting device %d: %v\n", i, err)
+			exitCode = exitError
+			continue
 		}
-	}
-	numGpus = uint(len(devices))
-	fmt.Printf("Number of valid devices: %d\n", numGpus)
-	for i := uint(0); i < numGpus; i++ {
-		for j := uint(0); j < numGpus; j++ {
-			topo := nvml.P2PLink{BusID: devices[j].PCI.BusID, Link: nvml.P2PLinkUnknown}
-			if i != j {
-				topoType, err := nvml.GetP2PLink(&devices[i], &devices[j])
-				if err != nil {
-					fmt.Printf("Error getting topology between %d and %d\n", i, j)
+		uuids = append(uuids, dev.UUID)
+		eccErrors, temperature := "-", "-"
+		status, err := dev.Status()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting status of device %d: %v\n", i, err)
+			exitCode = exitError
+		} else {
+			if status.Memory.ECCErrors.Device != nil {
+				eccErrors = strconv.FormatUint(*status.Memory.ECCErrors.Device, 10)
+				if *status.Memory.ECCErrors.Device > 0 && exitCode == exitOK {
+					exitCode = exitUnhealthy
 				}
-				topo.Link = topoType
 			}
-			devices[i].Topology = append(devices[i].Topology, topo)
+			if status.Temperature != nil {
+				temperature = strconv.Itoa(int(*status.Temperature))
+			}
 		}
+		fmt.Fprintf(w, "%d\t%v\t%v\t%v\t%v\n", i, dev.UUID, dev.PCI.BusID, eccErrors, temperature)
 	}
-	for i := uint(0); i < numGpus; i++ {
-		fmt.Printf("Devce %d info:\n", i)
-		fmt.Printf("%+v\n", devices[i])
+	w.Flush()
+	if *wait <= 0 {
+		return exitCode
 	}
+
+	fmt.Printf("\nListening for events for %v\n", *wait)
+	source := mnvml.NewEventSource()
+	defer source.Close()
+	if err := source.Register(uuids); err != nil {
+		fmt.Fprintf(os.Stderr, "Error registering for events: %v\n", err)
+		return exitError
+	}
+	for deadline := time.Now().Add(*wait); time.Now().Before(deadline); {
+		event, err := source.Wait(deadline.Sub(time.Now()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error waiting for events: %v\n", err)
+			return exitError
+		}
+		if event == nil {
+			continue
+		}
+		reason := nvidia.HealthEventReason(event, nvidia.DefaultRetiredPagesThreshold)
+		if reason == "" {
+			fmt.Printf("%v: event %v %v, not critical\n", event.UUID, event.Type, event.Data)
+		} else {
+			fmt.Printf("%v: event %v %v, unhealthy: %v\n", event.UUID, event.Type, event.Data, reason)
+			if exitCode == exitOK {
+				exitCode = exitUnhealthy
+			}
+		}
+	}
+	return exitCode
 }
 
 func main() {
-	if len(os.Args) > 1 {
-		fmt.Printf("%s", string(mnvml.GetDevicesJSON()))
-	} else {
-		printInfo()
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "%s", usage)
+		os.Exit(exitUsage)
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %v\n\n%s", os.Args[1], usage)
+		os.Exit(exitUsage)
 	}
+	os.Exit(run(os.Args[2:]))
 }
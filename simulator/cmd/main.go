@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Microsoft/KubeGPU/simulator"
+)
+
+func main() {
+	var clusterPath = flag.String("cluster", "", "Cluster description, JSON with a list of nodes and their GpusInfo inventory files.")
+	var tracePath = flag.String("trace", "", "Pod trace, JSON array of pods with Name, Arrival, GPUs, Duration and Topology (auto or none).")
+	var showPlacements = flag.Bool("placements", false, "Print the placement of each pod.")
+	flag.Parse()
+	if *clusterPath == "" || *tracePath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	nodes, err := simulator.LoadCluster(*clusterPath)
+	if err != nil {
+		fmt.Printf("Loading cluster encounters error %v\n", err)
+		os.Exit(1)
+	}
+	trace, err := simulator.LoadTrace(*tracePath)
+	if err != nil {
+		fmt.Printf("Loading trace encounters error %v\n", err)
+		os.Exit(1)
+	}
+	sim := simulator.New(nodes)
+	defer sim.Close()
+	report := sim.Run(trace)
+
+	if *showPlacements {
+		for _, p := range report.Placements {
+			fmt.Printf("%v: node %v start %.1f wait %.1f ideal %v GPUs %v\n", p.Pod, p.Node, p.Start, p.Wait, p.Ideal, strings.Join(p.GPUs, ","))
+		}
+	}
+	fmt.Printf("Nodes: %d GPUs: %d\n", len(nodes), report.TotalGPUs)
+	fmt.Printf("Pods: %d scheduled: %d unschedulable: %d\n", report.Pods, report.Scheduled, len(report.Unschedulable))
+	fmt.Printf("Makespan: %.1f s\n", report.Makespan)
+	fmt.Printf("Utilization: %.1f%%\n", 100*report.Utilization)
+	fmt.Printf("Wait: mean %.1f s max %.1f s\n", report.MeanWait, report.MaxWait)
+	fmt.Printf("Ideal topology: %.1f%%\n", 100*report.IdealTopologyRate)
+}
@@ -0,0 +1,118 @@
+package simulator
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/types"
+)
+
+// matches the GPU resources advertised by nodes and requested by translated pods
+var gpuResourceRE = regexp.MustCompile(`/gpugrp1/([^/]+)/gpugrp0/([^/]+)/gpu/([^/]+)/cards$`)
+
+type gpuLocation struct {
+	grp1 string
+	grp0 string
+	res  types.ResourceName
+}
+
+// Node is a node of the simulated cluster, the scheduler plugin accounts the GPUs in use in the used resources of its
+// node info
+type Node struct {
+	Name     string
+	Info     *types.NodeInfo
+	gpuGroup map[string]gpuLocation // GPU -> its groups
+	ideal    map[int][2]int         // number of GPUs -> fewest gpugrp1 and gpugrp0 groups holding them
+}
+
+func copyNodeInfo(info *types.NodeInfo) *types.NodeInfo {
+	nodeInfo := types.NewNodeInfo()
+	nodeInfo.Name = info.Name
+	for _, lists := range [][2]types.ResourceList{
+		{info.Capacity, nodeInfo.Capacity},
+		{info.Allocatable, nodeInfo.Allocatable},
+		{info.KubeCap, nodeInfo.KubeCap},
+		{info.KubeAlloc, nodeInfo.KubeAlloc},
+	} {
+		for key, val := range lists[0] {
+			lists[1][key] = val
+		}
+	}
+	return nodeInfo
+}
+
+func newNode(name string, info *types.NodeInfo) *Node {
+	node := &Node{Name: name, Info: info, gpuGroup: make(map[string]gpuLocation), ideal: make(map[int][2]int)}
+	for res := range info.Allocatable {
+		matches := gpuResourceRE.FindStringSubmatch(string(res))
+		if len(matches) == 4 {
+			node.gpuGroup[matches[3]] = gpuLocation{grp1: matches[1], grp0: matches[2], res: res}
+		}
+	}
+	return node
+}
+
+func (node *Node) numFree() int {
+	free := 0
+	for _, loc := range node.gpuGroup {
+		if node.Info.Used[loc.res] == 0 {
+			free++
+		}
+	}
+	return free
+}
+
+// matchedGPUs returns the GPUs the containers are matched to, sorted
+func matchedGPUs(matches map[string]types.ResourceLocation) []string {
+	gpus := []string{}
+	for _, allocateFrom := range matches {
+		for _, res := range allocateFrom {
+			if matches := gpuResourceRE.FindStringSubmatch(string(res)); len(matches) == 4 {
+				gpus = append(gpus, matches[3])
+			}
+		}
+	}
+	sort.Strings(gpus)
+	return gpus
+}
+
+// fewestGroups returns how many of the largest groups are needed to hold numGPUs
+func fewestGroups(sizes map[string]int, numGPUs int) int {
+	sorted := []int{}
+	for _, size := range sizes {
+		sorted = append(sorted, size)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	count := 0
+	for _, size := range sorted {
+		if numGPUs <= 0 {
+			break
+		}
+		numGPUs -= size
+		count++
+	}
+	return count
+}
+
+// isIdeal returns true if the GPUs are in as few groups as possible on an empty node
+func (node *Node) isIdeal(gpus []string) bool {
+	ideal, ok := node.ideal[len(gpus)]
+	if !ok {
+		grp1Sizes := make(map[string]int)
+		grp0Sizes := make(map[string]int)
+		for _, loc := range node.gpuGroup {
+			grp1Sizes[loc.grp1]++
+			grp0Sizes[loc.grp1+"/"+loc.grp0]++
+		}
+		ideal = [2]int{fewestGroups(grp1Sizes, len(gpus)), fewestGroups(grp0Sizes, len(gpus))}
+		node.ideal[len(gpus)] = ideal
+	}
+	grp1s := make(map[string]bool)
+	grp0s := make(map[string]bool)
+	for _, gpu := range gpus {
+		loc := node.gpuGroup[gpu]
+		grp1s[loc.grp1] = true
+		grp0s[loc.grp1+"/"+loc.grp0] = true
+	}
+	return len(grp1s) <= ideal[0] && len(grp0s) <= ideal[1]
+}
@@ -0,0 +1,340 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/types"
+	gputypes "github.com/Microsoft/KubeGPU/gpuplugintypes"
+	"github.com/Microsoft/KubeGPU/gpuschedulerplugin"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/fixtures"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvidia"
+)
+
+const (
+	// TopologyAuto lets the scheduler plugin generate the best topology for the pod
+	TopologyAuto = "auto"
+	// TopologyNone places the pod on any free GPUs
+	TopologyNone = "none"
+)
+
+// NodeSpec describes nodes of the cluster by their inventory, a GpusInfo JSON file in nvidia docker plugin units or as
+// printed by nvmlinfo json, or by a fixture design such as dgx1 and its options
+type NodeSpec struct {
+	Name      string
+	Inventory string // relative to the cluster file
+	Fixture   string // used if Inventory is not set
+	Options   fixtures.Options
+	Count     int // number of identical nodes, named <Name>-<i> if more than one
+}
+
+// ClusterSpec is the cluster description read by LoadCluster
+type ClusterSpec struct {
+	Nodes []NodeSpec
+}
+
+// PodSpec is a pod of the trace, times are in seconds
+type PodSpec struct {
+	Name     string
+	Arrival  float64
+	GPUs     int
+	Duration float64
+	Topology string // TopologyAuto (default) or TopologyNone
+}
+
+// Placement records where and when a pod was started
+type Placement struct {
+	Pod   string
+	Node  string
+	Start float64
+	Wait  float64
+	GPUs  []string
+	Ideal bool // placed in the fewest GPU groups possible on the node
+}
+
+// Report summarizes a simulation run
+type Report struct {
+	Pods              int
+	Scheduled         int
+	Unschedulable     []string
+	TotalGPUs         int
+	Makespan          float64 // from first arrival to last completion
+	Utilization       float64 // GPU time used over GPU time available during the makespan
+	MeanWait          float64
+	MaxWait           float64
+	IdealTopologyRate float64 // fraction of scheduled pods placed on their ideal topology
+	Placements        []Placement
+}
+
+// LoadCluster reads a cluster description and creates its nodes
+func LoadCluster(path string) ([]*Node, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec ClusterSpec
+	if err := json.Unmarshal(body, &spec); err != nil {
+		return nil, fmt.Errorf("parsing %v fails: %v", path, err)
+	}
+	nodes := []*Node{}
+	for _, nodeSpec := range spec.Nodes {
+		info, err := loadInventory(path, nodeSpec)
+		if err != nil {
+			return nil, err
+		}
+		count := nodeSpec.Count
+		if count <= 0 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			name := nodeSpec.Name
+			if count > 1 {
+				name += "-" + strconv.Itoa(i)
+			}
+			node, err := NewNode(name, info)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+// loadInventory reads the inventory of a node spec, or generates it from its fixture
+func loadInventory(clusterPath string, nodeSpec NodeSpec) (*nvgputypes.GpusInfo, error) {
+	if nodeSpec.Inventory == "" {
+		return fixtures.Generate(nodeSpec.Fixture, nodeSpec.Options)
+	}
+	inventory := nodeSpec.Inventory
+	if !filepath.IsAbs(inventory) {
+		inventory = filepath.Join(filepath.Dir(clusterPath), inventory)
+	}
+	body, err := ioutil.ReadFile(inventory)
+	if err != nil {
+		return nil, err
+	}
+	var info nvgputypes.GpusInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("parsing %v fails: %v", inventory, err)
+	}
+	if nvidia.InNVMLUnits(&info) {
+		nvidia.ToPluginUnits(&info)
+	}
+	return &info, nil
+}
+
+// LoadTrace reads a pod trace, a JSON array of PodSpec
+func LoadTrace(path string) ([]PodSpec, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pods []PodSpec
+	if err := json.Unmarshal(body, &pods); err != nil {
+		return nil, fmt.Errorf("parsing %v fails: %v", path, err)
+	}
+	return pods, nil
+}
+
+// NewNode computes the resources of a node from its inventory through the GPU manager
+func NewNode(name string, info *nvgputypes.GpusInfo) (*Node, error) {
+	d, err := nvidia.NewFakeNvidiaGPUManager(info, "", "")
+	if err != nil {
+		return nil, err
+	}
+	nodeInfo := types.NewNodeInfo()
+	nodeInfo.Name = name
+	if err := d.UpdateNodeInfo(nodeInfo); err != nil {
+		return nil, err
+	}
+	return newNode(name, nodeInfo), nil
+}
+
+// newPodInfo creates the pod info the scheduler plugin sees for a pod of the trace
+func newPodInfo(pod PodSpec) *types.PodInfo {
+	generate := int64(1)
+	if pod.Topology == TopologyNone {
+		generate = 0
+	}
+	return &types.PodInfo{
+		Name:     pod.Name,
+		Requests: types.ResourceList{gpuschedulerplugin.GPUTopologyGeneration: generate},
+		RunningContainers: map[string]types.ContainerInfo{
+			"main": {
+				KubeRequests: types.ResourceList{gputypes.ResourceGPU: int64(pod.GPUs)},
+				Requests:     types.ResourceList{gputypes.ResourceGPU: int64(pod.GPUs)},
+				DevRequests:  make(types.ResourceList),
+			},
+		},
+		InitContainers: map[string]types.ContainerInfo{},
+	}
+}
+
+type running struct {
+	pod  PodSpec
+	info *types.PodInfo
+	node *Node
+	end  float64
+}
+
+// Simulator replays pod traces against nodes through the GPU scheduler plugin
+type Simulator struct {
+	nodes     []*Node
+	scheduler *gpuschedulerplugin.NvidiaGPUScheduler
+}
+
+// New adds the nodes to the scheduler plugin, Close removes them again
+func New(nodes []*Node) *Simulator {
+	sim := &Simulator{nodes: nodes, scheduler: &gpuschedulerplugin.NvidiaGPUScheduler{AccountGPUs: true}}
+	sort.SliceStable(sim.nodes, func(i, j int) bool { return sim.nodes[i].Name < sim.nodes[j].Name })
+	for _, node := range sim.nodes {
+		// AddNode may translate the resources it is given, so pass a copy
+		nodeInfo := copyNodeInfo(node.Info)
+		sim.scheduler.AddNode(node.Name, nodeInfo)
+	}
+	return sim
+}
+
+// Close removes the nodes from the scheduler plugin
+func (sim *Simulator) Close() {
+	for _, node := range sim.nodes {
+		sim.scheduler.RemoveNode(node.Name)
+	}
+}
+
+// schedule evaluates the pod on every node and places it on the node with the highest score, the first in name order
+// on ties, the placement of the chosen node is allocated to the pod info, its GPUs are matched and filled in like the
+// group scheduler does and taken through the scheduler plugin
+func (sim *Simulator) schedule(pod PodSpec) (*Node, []string, bool, *types.PodInfo) {
+	podInfo := newPodInfo(pod)
+	var fitNode *Node
+	fitScore := 0.0
+	for _, node := range sim.nodes {
+		if node.numFree() < pod.GPUs {
+			continue
+		}
+		fits, _, score := sim.scheduler.PodFitsDevice(node.Info, podInfo, false)
+		if !fits {
+			continue
+		}
+		if _, failure := gpuschedulerplugin.MatchPod(node.Info, podInfo); failure != nil {
+			continue
+		}
+		if fitNode == nil || score > fitScore {
+			fitNode, fitScore = node, score
+		}
+	}
+	if fitNode == nil {
+		return nil, nil, false, nil
+	}
+	if err := sim.scheduler.PodAllocate(fitNode.Info, podInfo); err != nil {
+		return nil, nil, false, nil
+	}
+	matches, failure := gpuschedulerplugin.MatchPod(fitNode.Info, podInfo)
+	if failure != nil {
+		return nil, nil, false, nil
+	}
+	for name, allocateFrom := range matches {
+		cont := podInfo.RunningContainers[name]
+		cont.AllocateFrom = allocateFrom
+		podInfo.RunningContainers[name] = cont
+	}
+	if err := sim.scheduler.TakePodResources(fitNode.Info, podInfo); err != nil {
+		return nil, nil, false, nil
+	}
+	gpus := matchedGPUs(matches)
+	return fitNode, gpus, fitNode.isIdeal(gpus), podInfo
+}
+
+// Run replays the trace, pods are started first come first served, later pods may start before earlier ones that do not fit
+func (sim *Simulator) Run(trace []PodSpec) *Report {
+	report := &Report{}
+	maxNodeGPUs := 0
+	for _, node := range sim.nodes {
+		report.TotalGPUs += len(node.gpuGroup)
+		if len(node.gpuGroup) > maxNodeGPUs {
+			maxNodeGPUs = len(node.gpuGroup)
+		}
+	}
+	pods := append([]PodSpec{}, trace...)
+	sort.SliceStable(pods, func(i, j int) bool { return pods[i].Arrival < pods[j].Arrival })
+	report.Pods = len(pods)
+
+	pending := []PodSpec{}
+	runningPods := []*running{}
+	next := 0
+	start, end := math.Inf(1), 0.0
+	gpuTime, totalWait, ideal := 0.0, 0.0, 0
+	for {
+		now := math.Inf(1)
+		if next < len(pods) {
+			now = pods[next].Arrival
+		}
+		for _, r := range runningPods {
+			if r.end < now {
+				now = r.end
+			}
+		}
+		if math.IsInf(now, 1) {
+			break
+		}
+		// completions, then arrivals
+		stillRunning := []*running{}
+		for _, r := range runningPods {
+			if r.end <= now {
+				sim.scheduler.ReturnPodResources(r.node.Info, r.info)
+			} else {
+				stillRunning = append(stillRunning, r)
+			}
+		}
+		runningPods = stillRunning
+		for ; next < len(pods) && pods[next].Arrival <= now; next++ {
+			if pods[next].GPUs > maxNodeGPUs {
+				report.Unschedulable = append(report.Unschedulable, pods[next].Name)
+				continue
+			}
+			start = math.Min(start, pods[next].Arrival)
+			pending = append(pending, pods[next])
+		}
+		stillPending := []PodSpec{}
+		for _, pod := range pending {
+			node, gpus, isIdeal, podInfo := sim.schedule(pod)
+			if node == nil {
+				stillPending = append(stillPending, pod)
+				continue
+			}
+			runningPods = append(runningPods, &running{pod: pod, info: podInfo, node: node, end: now + pod.Duration})
+			wait := now - pod.Arrival
+			report.Placements = append(report.Placements, Placement{Pod: pod.Name, Node: node.Name, Start: now, Wait: wait, GPUs: gpus, Ideal: isIdeal})
+			report.MaxWait = math.Max(report.MaxWait, wait)
+			totalWait += wait
+			gpuTime += float64(pod.GPUs) * pod.Duration
+			end = math.Max(end, now+pod.Duration)
+			if isIdeal {
+				ideal++
+			}
+		}
+		pending = stillPending
+	}
+	// pods which did not fit on an empty cluster
+	for _, pod := range pending {
+		report.Unschedulable = append(report.Unschedulable, pod.Name)
+	}
+	report.Scheduled = len(report.Placements)
+	if report.Scheduled > 0 {
+		report.Makespan = end - start
+		report.MeanWait = totalWait / float64(report.Scheduled)
+		report.IdealTopologyRate = float64(ideal) / float64(report.Scheduled)
+		if report.Makespan > 0 && report.TotalGPUs > 0 {
+			report.Utilization = gpuTime / (report.Makespan * float64(report.TotalGPUs))
+		}
+	}
+	return report
+}
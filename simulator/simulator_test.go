@@ -0,0 +1,146 @@
+package simulator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Microsoft/KubeDevice-API/pkg/types"
+	"github.com/Microsoft/KubeGPU/nvidiagpuplugin/gpu/nvgputypes"
+)
+
+// fourGPUs returns a node with two pairs of GPUs behind PCIe switches on one CPU
+func fourGPUs(prefix string) *nvgputypes.GpusInfo {
+	info := &nvgputypes.GpusInfo{Version: nvgputypes.VersionInfo{Driver: "418.87.01", CUDA: "10.1"}}
+	for i := 0; i < 4; i++ {
+		gpu := nvgputypes.GpuInfo{
+			ID:     prefix + "-GPU" + strconv.Itoa(i),
+			Path:   "/dev/nvidia" + strconv.Itoa(i),
+			Memory: nvgputypes.MemoryInfo{Global: 16160},
+			PCI:    nvgputypes.PciInfo{BusID: "0000:0" + strconv.Itoa(i) + ":00.0"},
+		}
+		for j := 0; j < 4; j++ {
+			link := int32(3)
+			if i/2 == j/2 {
+				link = 5
+			}
+			if i != j {
+				gpu.Topology = append(gpu.Topology, nvgputypes.TopologyInfo{BusID: "0000:0" + strconv.Itoa(j) + ":00.0", Link: link})
+			}
+		}
+		info.Gpus = append(info.Gpus, gpu)
+	}
+	return info
+}
+
+func TestRun(t *testing.T) {
+	nodeA, err := NewNode("a", fourGPUs("a"))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	nodeB, _ := NewNode("b", fourGPUs("b"))
+	sim := New([]*Node{nodeB, nodeA})
+	defer sim.Close()
+	report := sim.Run([]PodSpec{
+		{Name: "p0", Arrival: 0, GPUs: 2, Duration: 10},
+		{Name: "p1", Arrival: 0, GPUs: 4, Duration: 10},
+		{Name: "p2", Arrival: 1, GPUs: 4, Duration: 5},
+		{Name: "p3", Arrival: 2, GPUs: 2, Duration: 8, Topology: TopologyNone},
+		{Name: "big", Arrival: 3, GPUs: 16, Duration: 1},
+	})
+	if report.Pods != 5 || report.Scheduled != 4 || len(report.Unschedulable) != 1 || report.Unschedulable[0] != "big" {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if report.TotalGPUs != 8 {
+		t.Errorf("Expected 8 GPUs, have %v", report.TotalGPUs)
+	}
+	placements := make(map[string]Placement)
+	numGPUs := map[string]int{"p0": 2, "p1": 4, "p2": 4, "p3": 2}
+	for _, p := range report.Placements {
+		placements[p.Pod] = p
+		if len(p.GPUs) != numGPUs[p.Pod] {
+			t.Errorf("Pod %v placed on %v", p.Pod, p.GPUs)
+		}
+		if !p.Ideal {
+			t.Errorf("Pod %v not placed ideally on %v", p.Pod, p.GPUs)
+		}
+	}
+	// p0 and p1 start right away on separate nodes, p3 fills node a, p2 waits for p1
+	if placements["p0"].Node != "a" || placements["p1"].Node != "b" || placements["p3"].Node != "a" {
+		t.Errorf("Unexpected placements %+v", report.Placements)
+	}
+	if placements["p2"].Start != 10 || placements["p2"].Wait != 9 || report.MaxWait != 9 {
+		t.Errorf("Unexpected start of p2 %+v", placements["p2"])
+	}
+	if report.Makespan != 15 {
+		t.Errorf("Expected makespan 15, have %v", report.Makespan)
+	}
+	// 2*10 + 4*10 + 4*5 + 2*8 GPU seconds over 8 GPUs for 15 seconds
+	if expected := 96.0 / 120.0; report.Utilization != expected {
+		t.Errorf("Expected utilization %v, have %v", expected, report.Utilization)
+	}
+}
+
+// occupy takes the GPUs of the node through the scheduler plugin like a pod allocated from them
+func occupy(t *testing.T, sim *Simulator, node *Node, gpus ...string) {
+	allocateFrom := make(types.ResourceLocation)
+	for i, gpu := range gpus {
+		allocateFrom[types.ResourceName("occupied/"+strconv.Itoa(i))] = node.gpuGroup[gpu].res
+	}
+	pod := &types.PodInfo{Name: "occupied", RunningContainers: map[string]types.ContainerInfo{"main": {AllocateFrom: allocateFrom}}}
+	if err := sim.scheduler.TakePodResources(node.Info, pod); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+}
+
+func TestScheduleByScore(t *testing.T) {
+	nodeA, _ := NewNode("a", fourGPUs("a"))
+	nodeB, _ := NewNode("b", fourGPUs("b"))
+	sim := New([]*Node{nodeA, nodeB})
+	defer sim.Close()
+	// node a only has one free GPU of each pair, so the pair of node b scores higher
+	occupy(t, sim, nodeA, "a-GPU0", "a-GPU2")
+	node, gpus, isIdeal, podInfo := sim.schedule(PodSpec{Name: "p0", GPUs: 2})
+	if node != nodeB || !reflect.DeepEqual(gpus, []string{"b-GPU0", "b-GPU1"}) || !isIdeal || podInfo == nil {
+		t.Errorf("Expected the pair of node b, have %v %v", node, gpus)
+	}
+	// without a pair left, the split GPUs of node a are used, the GPUs of p0 were taken when it was scheduled
+	occupy(t, sim, nodeB, "b-GPU2")
+	if node, gpus, isIdeal, _ = sim.schedule(PodSpec{Name: "p1", GPUs: 2}); node != nodeA || isIdeal || !reflect.DeepEqual(gpus, []string{"a-GPU1", "a-GPU3"}) {
+		t.Errorf("Expected the free GPUs of node a, have %v %v", node, gpus)
+	}
+}
+
+func TestIdeal(t *testing.T) {
+	node, _ := NewNode("a", fourGPUs("a"))
+	sim := New([]*Node{node})
+	defer sim.Close()
+	gpus := []string{"a-GPU0", "a-GPU1", "a-GPU2", "a-GPU3"}
+	if !node.isIdeal(gpus[:2]) || node.isIdeal([]string{gpus[0], gpus[2]}) || !node.isIdeal(gpus[:3]) {
+		t.Errorf("Unexpected ideal placements")
+	}
+	occupy(t, sim, node, gpus[1])
+	if node.numFree() != 3 {
+		t.Errorf("Expected three free GPUs")
+	}
+}
+
+func TestLoadCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simulator")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "cluster.json"), []byte(`{"Nodes": [{"Name": "pcie", "Inventory": "node.json", "Count": 3}, {"Name": "dgx", "Fixture": "dgx1"}]}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "node.json"), []byte(`{"Version":{"Driver":"384.111","CUDA":"9.0"},"Devices":[{"UUID":"GPU0","Path":"/dev/nvidia0","Model":"Tesla K80","PCI":{"BusID":"777C:00:00.0","Bandwidth":15760},"Topology":null,"Memory":{"Global":11439}}]}`), 0644)
+	nodes, err := LoadCluster(filepath.Join(dir, "cluster.json"))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if len(nodes) != 4 || nodes[2].Name != "pcie-2" || nodes[0].numFree() != 1 || nodes[3].Name != "dgx" || nodes[3].numFree() != 8 {
+		t.Errorf("Unexpected nodes %+v", nodes)
+	}
+}